@@ -0,0 +1,208 @@
+/*********************************************************
+*   package:    alerts
+*   desc:       fires and tracks alerts on low toner, repeated scrape failures, and state
+*               transitions, de-duplicating against whatever is already active and notifying
+*               through whichever backends the config enables
+*   files:      alerts.go
+*   author:     rory dudley (pinecat)
+*********************************************************/
+
+package alerts
+
+import (
+    "fmt" // for building alert messages
+    "sort" // for returning active alerts sorted by how long they've been firing
+    "sync" // for guarding the active alert map across the poll loop and the web ui
+    "time" // for de-duplication windows and timestamps
+)
+
+/* Kind identifies what kind of condition an alert represents */
+type Kind string
+
+const (
+    KindLowToner    Kind = "low_toner"    // a printer's toner dropped below its configured threshold
+    KindDown        Kind = "down"         // a printer has failed to scrape/poll N times in a row
+    KindDeviceError Kind = "device_error" // a printer transitioned to an error condition: prtAlertTable entry or a non-running hrDeviceStatus
+)
+
+/* Alert is one active or recently-resolved condition on a printer */
+type Alert struct {
+    Printer      string    // printer display name
+    Kind         Kind      // what kind of condition this is
+    Message      string    // human-readable detail, e.g. "toner at 4%"
+    FirstSeen    time.Time // when this condition first became active
+    LastSeen     time.Time // the most recent time this condition was still active
+    LastNotified time.Time // the last time a notifier was actually sent for this alert
+    Resolved     bool      // true once the condition has cleared
+    ResolvedAt   time.Time // when it cleared, zero if still active
+    Acknowledged bool      // true once a human has acknowledged it in the web ui
+}
+
+/* key identifies an alert's condition, independent of how many times it's fired */
+func (a Alert) key() string {
+    return a.Printer + "|" + string(a.Kind)
+}
+
+/* Notifier is a pluggable backend that an Engine sends alerts through */
+type Notifier interface {
+    Notify(a Alert) error
+}
+
+/* Engine tracks active alerts and decides when a condition is new enough, or old enough, to notify on */
+type Engine struct {
+    mu            sync.Mutex
+    store         *Store
+    notifiers     []Notifier
+    renotifyAfter time.Duration
+    active        map[string]*Alert
+}
+
+/*
+    NewEngine: builds an alert Engine, restoring any still-active alerts from the store
+    params:    store - the persistent store backing acknowledgements and alert history, may be nil
+               notifiers - the notifier backends to send through, in config order
+               renotifyAfter - how long an unresolved alert stays quiet before notifying again
+    returns:   e - the new engine
+*/
+func NewEngine(store *Store, notifiers []Notifier, renotifyAfter time.Duration) *Engine {
+    e := &Engine{
+        store:         store,
+        notifiers:     notifiers,
+        renotifyAfter: renotifyAfter,
+        active:        make(map[string]*Alert),
+    }
+    if store != nil {
+        for _, a := range store.All() {
+            if !a.Resolved {
+                cp := a
+                e.active[a.key()] = &cp
+            }
+        }
+    }
+    return e
+}
+
+/*
+    Evaluate: reports whether a condition is currently true for a printer, firing, renotifying,
+              or resolving an alert as needed; safe to call on every poll
+    params:   printer - the printer's display name
+              kind - which condition this is
+              active - whether the condition holds right now
+              message - human-readable detail, used when (re)firing
+    returns:  void
+*/
+func (e *Engine) Evaluate(printer string, kind Kind, active bool, message string) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    k := Alert{Printer: printer, Kind: kind}.key()
+    now := time.Now()
+    existing, ok := e.active[k]
+
+    if !active {
+        if !ok { // wasn't alerting, still isn't: nothing to do
+            return
+        }
+        existing.Resolved = true
+        existing.ResolvedAt = now
+        e.notify(*existing)
+        e.persist(*existing)
+        delete(e.active, k)
+        return
+    }
+
+    if !ok { // a brand new condition
+        a := &Alert{Printer: printer, Kind: kind, Message: message, FirstSeen: now, LastSeen: now, LastNotified: now}
+        e.active[k] = a
+        e.notify(*a)
+        e.persist(*a)
+        return
+    }
+
+    existing.LastSeen = now
+    existing.Message = message
+    if now.Sub(existing.LastNotified) >= e.renotifyAfter { // still active, but it's been quiet long enough to remind someone
+        existing.LastNotified = now
+        e.notify(*existing)
+    }
+    e.persist(*existing)
+}
+
+/*
+    SetNotifiers: swaps in a new set of notifier backends and renotify window, e.g. after a config hot reload
+    params:       notifiers - the new notifier backends
+                  renotifyAfter - the new renotify window
+    returns:      void
+*/
+func (e *Engine) SetNotifiers(notifiers []Notifier, renotifyAfter time.Duration) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    e.notifiers = notifiers
+    e.renotifyAfter = renotifyAfter
+}
+
+/* notify sends an alert through every configured notifier, logging (not failing the poll) on error */
+func (e *Engine) notify(a Alert) {
+    for _, n := range e.notifiers {
+        if err := n.Notify(a); err != nil {
+            fmt.Printf("alert notifier failed for %s/%s: %v\n", a.Printer, a.Kind, err) // a bad notifier shouldn't take down the poll loop
+        }
+    }
+}
+
+/* persist saves an alert to the store, if one is configured */
+func (e *Engine) persist(a Alert) {
+    if e.store != nil {
+        e.store.Save(a)
+    }
+}
+
+/*
+    Active: returns every currently-active alert, sorted by how long ago it first fired
+    params:  n/a
+    returns: alerts - the active alerts, oldest first
+*/
+func (e *Engine) Active() []Alert {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    out := make([]Alert, 0, len(e.active))
+    for _, a := range e.active {
+        out = append(out, *a)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].FirstSeen.Before(out[j].FirstSeen) })
+    return out
+}
+
+/*
+    Recent: returns every alert the store knows about, active or resolved, for the /alerts page
+    params:  n/a
+    returns: alerts - every stored alert
+*/
+func (e *Engine) Recent() []Alert {
+    if e.store == nil {
+        return e.Active()
+    }
+    return e.store.All()
+}
+
+/*
+    Acknowledge: marks an alert as acknowledged in the store, so the web ui stops calling attention to it
+    params:      printer - the printer's display name
+                 kind - which condition to acknowledge
+    returns:     err - non-nil if there's no store configured, or the alert isn't known
+*/
+func (e *Engine) Acknowledge(printer string, kind Kind) error {
+    if e.store == nil {
+        return fmt.Errorf("no alert store configured")
+    }
+
+    e.mu.Lock()
+    if a, ok := e.active[Alert{Printer: printer, Kind: kind}.key()]; ok {
+        a.Acknowledged = true
+        e.persist(*a)
+    }
+    e.mu.Unlock()
+
+    return e.store.Acknowledge(printer, kind)
+}
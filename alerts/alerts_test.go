@@ -0,0 +1,102 @@
+/*********************************************************
+*   package:    alerts
+*   desc:       tests for Engine.Evaluate's firing, dedup, renotify, and resolve transitions
+*   files:      alerts_test.go
+*   author:     rory dudley (pinecat)
+*********************************************************/
+
+package alerts
+
+import (
+    "testing"
+    "time"
+)
+
+/* recordingNotifier counts how many times Notify was called, and remembers the last Alert it saw */
+type recordingNotifier struct {
+    calls int
+    last  Alert
+}
+
+func (n *recordingNotifier) Notify(a Alert) error {
+    n.calls++
+    n.last = a
+    return nil
+}
+
+func TestEvaluateFiresOnNewCondition(t *testing.T) {
+    n := &recordingNotifier{}
+    e := NewEngine(nil, []Notifier{n}, time.Hour)
+
+    e.Evaluate("lobby", KindLowToner, true, "toner at 4%")
+
+    if n.calls != 1 {
+        t.Fatalf("Notify called %d times, want 1", n.calls)
+    }
+    if n.last.Resolved {
+        t.Errorf("first fire should not be Resolved")
+    }
+
+    active := e.Active()
+    if len(active) != 1 || active[0].Printer != "lobby" || active[0].Kind != KindLowToner {
+        t.Errorf("Active() = %+v, want one low_toner alert for lobby", active)
+    }
+}
+
+func TestEvaluateDedupsWithinRenotifyWindow(t *testing.T) {
+    n := &recordingNotifier{}
+    e := NewEngine(nil, []Notifier{n}, time.Hour)
+
+    e.Evaluate("lobby", KindLowToner, true, "toner at 4%")
+    e.Evaluate("lobby", KindLowToner, true, "toner at 3%") // still active, well within the renotify window
+
+    if n.calls != 1 {
+        t.Fatalf("Notify called %d times, want 1 (second poll should be deduped)", n.calls)
+    }
+
+    active := e.Active()
+    if len(active) != 1 || active[0].Message != "toner at 3%" {
+        t.Errorf("Active() should still reflect the latest message even when not renotifying, got %+v", active)
+    }
+}
+
+func TestEvaluateRenotifiesAfterWindowElapses(t *testing.T) {
+    n := &recordingNotifier{}
+    e := NewEngine(nil, []Notifier{n}, 0) // a zero window means every still-active poll is due to renotify
+
+    e.Evaluate("lobby", KindLowToner, true, "toner at 4%")
+    e.Evaluate("lobby", KindLowToner, true, "toner at 3%")
+
+    if n.calls != 2 {
+        t.Fatalf("Notify called %d times, want 2 (renotify window elapsed before the second poll)", n.calls)
+    }
+}
+
+func TestEvaluateResolvesCondition(t *testing.T) {
+    n := &recordingNotifier{}
+    e := NewEngine(nil, []Notifier{n}, time.Hour)
+
+    e.Evaluate("lobby", KindDown, true, "3 consecutive failures")
+    e.Evaluate("lobby", KindDown, false, "")
+
+    if n.calls != 2 {
+        t.Fatalf("Notify called %d times, want 2 (one fire, one resolve)", n.calls)
+    }
+    if !n.last.Resolved {
+        t.Errorf("last notify should be the resolved transition")
+    }
+    if len(e.Active()) != 0 {
+        t.Errorf("Active() should be empty once the condition resolves, got %+v", e.Active())
+    }
+}
+
+func TestEvaluateNoopWhenNeverActive(t *testing.T) {
+    n := &recordingNotifier{}
+    e := NewEngine(nil, []Notifier{n}, time.Hour)
+
+    e.Evaluate("lobby", KindDown, false, "") // wasn't down, still isn't
+
+    if n.calls != 0 {
+        t.Errorf("Notify called %d times, want 0 for a condition that was never active", n.calls)
+    }
+}
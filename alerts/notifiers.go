@@ -0,0 +1,113 @@
+/*********************************************************
+*   program:    origami
+*   desc:       notifier backends for the alerts package: smtp email, chat/generic webhooks,
+*               and a local log file
+*   files:      notifiers.go
+*   author:     rory dudley (pinecat)
+*********************************************************/
+
+package alerts
+
+import (
+    "bytes" // for building the webhook request body
+    "encoding/json" // for encoding webhook payloads
+    "fmt" // for formatting alert messages
+    "net/http" // for posting to webhooks
+    "net/smtp" // for sending the email notifications
+    "os" // for appending to the log file
+    "time" // for timestamping log lines
+)
+
+/* format renders an alert the same way across every notifier, so they all read consistently */
+func format(a Alert) string {
+    if a.Resolved {
+        return fmt.Sprintf("[origami] RESOLVED %s on %s (was active since %s)", a.Kind, a.Printer, a.FirstSeen.Format("2006-01-02 15:04:05"))
+    }
+    return fmt.Sprintf("[origami] %s on %s: %s", a.Kind, a.Printer, a.Message)
+}
+
+/* SMTPNotifier emails an alert through an smtp relay, with optional auth */
+type SMTPNotifier struct {
+    Host     string
+    Port     int
+    Username string // empty to send unauthenticated, e.g. an internal relay
+    Password string
+    From     string
+    To       []string
+}
+
+func (n *SMTPNotifier) Notify(a Alert) error {
+    addr := fmt.Sprintf("%s:%d", n.Host, n.Port)
+    subject := format(a)
+    body := fmt.Sprintf("To: %s\r\nFrom: %s\r\nSubject: %s\r\n\r\n%s\r\n", joinAddrs(n.To), n.From, subject, subject)
+
+    var auth smtp.Auth
+    if n.Username != "" {
+        auth = smtp.PlainAuth("", n.Username, n.Password, n.Host)
+    }
+    return smtp.SendMail(addr, auth, n.From, n.To, []byte(body))
+}
+
+func joinAddrs(addrs []string) string {
+    out := ""
+    for i, a := range addrs {
+        if i > 0 {
+            out += ", "
+        }
+        out += a
+    }
+    return out
+}
+
+/* WebhookNotifier posts an alert to a chat webhook; Format picks the payload shape the target expects */
+type WebhookNotifier struct {
+    URL    string
+    Format string // "slack", "discord", "teams", or "generic"
+}
+
+func (n *WebhookNotifier) Notify(a Alert) error {
+    text := format(a)
+
+    var payload []byte
+    var err error
+    switch n.Format {
+    case "discord":
+        payload, err = json.Marshal(map[string]string{"content": text})
+    case "teams":
+        payload, err = json.Marshal(map[string]string{"text": text}) // teams' "MessageCard" connector accepts a bare text field too
+    case "slack":
+        payload, err = json.Marshal(map[string]string{"text": text})
+    default: // "generic": ship the whole alert as json, for whatever's listening
+        payload, err = json.Marshal(a)
+    }
+    if err != nil {
+        return fmt.Errorf("could not encode webhook payload: %w", err)
+    }
+
+    resp, err := http.Post(n.URL, "application/json", bytes.NewReader(payload))
+    if err != nil {
+        return fmt.Errorf("could not post to webhook: %w", err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("webhook returned status %s", resp.Status)
+    }
+    return nil
+}
+
+/* LogFileNotifier appends a line per alert to a local file, for setups that don't want email or a webhook at all */
+type LogFileNotifier struct {
+    Path string
+}
+
+func (n *LogFileNotifier) Notify(a Alert) error {
+    f, err := os.OpenFile(n.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+    if err != nil {
+        return fmt.Errorf("could not open alert log file: %w", err)
+    }
+    defer f.Close()
+
+    line := fmt.Sprintf("%s %s\n", time.Now().Format("2006-01-02 15:04:05"), format(a))
+    _, err = f.WriteString(line)
+    return err
+}
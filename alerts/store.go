@@ -0,0 +1,104 @@
+/*********************************************************
+*   program:    origami
+*   desc:       persists alerts to a small embedded boltdb file, so acknowledgements and alert
+*               history survive a restart
+*   files:      store.go
+*   author:     rory dudley (pinecat)
+*********************************************************/
+
+package alerts
+
+import (
+    "encoding/json" // for serializing alerts into the store
+    "fmt" // for wrapping store errors
+    "sort" // for returning alerts oldest-first
+
+    "go.etcd.io/bbolt" // for the embedded, single-file key/value store backing alert persistence
+)
+
+var alertsBucket = []byte("alerts")
+
+/* Store is a boltdb-backed record of every alert that has ever fired, active or resolved */
+type Store struct {
+    db *bbolt.DB
+}
+
+/*
+    OpenStore: opens (creating if necessary) the boltdb file used to persist alerts
+    params:    path - path to the boltdb file
+    returns:   store - the opened store
+               err - non-nil if the file could not be opened
+*/
+func OpenStore(path string) (*Store, error) {
+    db, err := bbolt.Open(path, 0644, nil)
+    if err != nil {
+        return nil, fmt.Errorf("could not open alert store %s: %w", path, err)
+    }
+    err = db.Update(func(tx *bbolt.Tx) error {
+        _, err := tx.CreateBucketIfNotExists(alertsBucket)
+        return err
+    })
+    if err != nil {
+        db.Close()
+        return nil, fmt.Errorf("could not initialize alert store: %w", err)
+    }
+    return &Store{db: db}, nil
+}
+
+/* Close closes the underlying boltdb file */
+func (s *Store) Close() error {
+    return s.db.Close()
+}
+
+/* Save writes (or overwrites) one alert's record, keyed by printer+kind */
+func (s *Store) Save(a Alert) {
+    data, err := json.Marshal(a)
+    if err != nil {
+        return // an alert should always be json-marshalable; if it somehow isn't, there's nothing useful to do here
+    }
+    s.db.Update(func(tx *bbolt.Tx) error {
+        return tx.Bucket(alertsBucket).Put([]byte(a.key()), data)
+    })
+}
+
+/* Acknowledge marks a stored alert as acknowledged, leaving everything else about it untouched */
+func (s *Store) Acknowledge(printer string, kind Kind) error {
+    key := []byte(Alert{Printer: printer, Kind: kind}.key())
+    return s.db.Update(func(tx *bbolt.Tx) error {
+        b := tx.Bucket(alertsBucket)
+        data := b.Get(key)
+        if data == nil {
+            return fmt.Errorf("no alert recorded for %s/%s", printer, kind)
+        }
+        var a Alert
+        if err := json.Unmarshal(data, &a); err != nil {
+            return err
+        }
+        a.Acknowledged = true
+        out, err := json.Marshal(a)
+        if err != nil {
+            return err
+        }
+        return b.Put(key, out)
+    })
+}
+
+/*
+    All: returns every alert in the store, oldest-first by when it was first seen
+    params:  n/a
+    returns: alerts - every stored alert
+*/
+func (s *Store) All() []Alert {
+    var out []Alert
+    s.db.View(func(tx *bbolt.Tx) error {
+        return tx.Bucket(alertsBucket).ForEach(func(k, v []byte) error {
+            var a Alert
+            if err := json.Unmarshal(v, &a); err == nil {
+                out = append(out, a)
+            }
+            return nil
+        })
+    })
+    sort.Slice(out, func(i, j int) bool { return out[i].FirstSeen.Before(out[j].FirstSeen) })
+    return out
+}
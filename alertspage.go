@@ -0,0 +1,133 @@
+/*********************************************************
+*   program:    origami
+*   desc:       /alerts web ui: lists active and recent alerts, with acknowledge buttons
+*   files:      alertspage.go
+*   author:     rory dudley (pinecat)
+*********************************************************/
+
+package main
+
+import (
+    "html/template" // html template for the alerts page
+    "net/http" // for the handlers themselves
+    "github.com/pinecat/origamiv2/alerts" // for alerts.Kind
+)
+
+/* alertsPage is the template for the /alerts page */
+var alertsPage string = `
+    <!DOCTYPE html>
+    <head>
+        <title>OrigamiV2 - Alerts</title>
+        <style>
+            body {background-color: #3560A5;}
+            #heading {
+                background-color: #292A47;
+                color: white;
+                width: 100%;
+                padding: 20px;
+                margin-top: -10px;
+                margin-left: -10px;
+            }
+            #heading a {
+                color: white;
+                text-decoration: none;
+            }
+            table {
+                border: 2px solid;
+                background-color: #FFFFFF;
+            }
+            th, td {
+                border: 2px solid;
+                padding: 8px 16px;
+            }
+            .resolved { color: #3FAE49; }
+            .active { color: #C65959; }
+        </style>
+    </head>
+
+    <body>
+        <div id="heading">
+            <h1>Alerts</h1>
+            <h4><a href="/">Back to printers</a></h4>
+        </div>
+
+        <table>
+            <thead>
+                <th>Status</th>
+                <th>Printer</th>
+                <th>Kind</th>
+                <th>Message</th>
+                <th>First Seen</th>
+                <th>Last Seen</th>
+                <th>Acknowledged</th>
+                <th></th>
+            </thead>
+            {{ range .}}
+            <tr>
+                <td class="{{ if .Resolved }}resolved{{ else }}active{{ end }}">{{ if .Resolved }}resolved{{ else }}active{{ end }}</td>
+                <td>{{ .Printer }}</td>
+                <td>{{ .Kind }}</td>
+                <td>{{ .Message }}</td>
+                <td>{{ .FirstSeen.Format "2006-01-02_15:04:05" }}</td>
+                <td>{{ .LastSeen.Format "2006-01-02_15:04:05" }}</td>
+                <td>{{ .Acknowledged }}</td>
+                <td>
+                    {{ if not .Acknowledged }}
+                    <form method="POST" action="/alerts/ack">
+                        <input type="hidden" name="printer" value="{{ .Printer }}">
+                        <input type="hidden" name="kind" value="{{ .Kind }}">
+                        <input type="submit" value="Acknowledge">
+                    </form>
+                    {{ end }}
+                </td>
+            </tr>
+            {{ end }}
+        </table>
+    </body>
+`
+
+/*
+    alertsHandler: serves the /alerts page, listing every alert the engine knows about, newest first
+    params:        w - http response writer
+                   r - http request
+    returns:       void
+*/
+func alertsHandler(w http.ResponseWriter, r *http.Request) {
+    if alertEngine == nil {
+        http.Error(w, "alerts are not configured", http.StatusNotFound)
+        return
+    }
+
+    recent := alertEngine.Recent()
+    for i, j := 0, len(recent)-1; i < j; i, j = i+1, j-1 { // reverse into newest-first, since Recent() returns oldest-first
+        recent[i], recent[j] = recent[j], recent[i]
+    }
+
+    t, _ := template.New("alerts").Parse(alertsPage)
+    t.Execute(w, recent)
+}
+
+/*
+    alertsAckHandler: handles the acknowledge button's form post from the /alerts page
+    params:           w - http response writer
+                      r - http request
+    returns:          void
+*/
+func alertsAckHandler(w http.ResponseWriter, r *http.Request) {
+    if alertEngine == nil {
+        http.Error(w, "alerts are not configured", http.StatusNotFound)
+        return
+    }
+    if r.Method != http.MethodPost {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    printer := r.FormValue("printer")
+    kind := r.FormValue("kind")
+    if err := alertEngine.Acknowledge(printer, alerts.Kind(kind)); err != nil {
+        dispError("Could not acknowledge alert", err)
+    }
+
+    http.Redirect(w, r, "/alerts", http.StatusSeeOther)
+}
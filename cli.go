@@ -0,0 +1,155 @@
+/*********************************************************
+*   program:    origami
+*   desc:       command-line entrypoint: serve / check / dump / validate subcommands
+*   files:      cli.go
+*   author:     rory dudley (pinecat)
+*********************************************************/
+
+package main
+
+import (
+    "encoding/csv" // for --format=csv in "origami dump"
+    "encoding/json" // for --format=json in "origami dump"
+    "fmt" // for printing results to stdout
+    "os" // for exiting with a status code and writing to stdout
+    "time" // for one-shot poll timeouts
+    "github.com/jessevdk/go-flags" // for parsing flags and subcommands
+    "github.com/pinecat/origamiv2/config" // for loading/validating the yaml config directly
+)
+
+const defaultLowThreshold = 10 // percent; used by "origami check" and the alerts subsystem for a printer with no low_threshold of its own
+
+/* opts holds the flags shared across every subcommand */
+var opts struct {
+    Config string `short:"f" long:"config" env:"ORIGAMI_CONFIG" default:"origami.conf" description:"path to the configuration file"`
+}
+
+/* serveCmd is "origami serve": run the web server and poll printers on an interval (the original behavior, now behind an explicit subcommand) */
+type serveCmd struct {
+    Port     string `short:"p" long:"port" env:"ORIGAMI_PORT" description:"override the port read from the config file"`
+    Interval int    `short:"i" long:"interval" env:"ORIGAMI_INTERVAL" description:"override the poll interval, in minutes, read from the config file"`
+}
+
+func (c *serveCmd) Execute(args []string) error {
+    runServe(opts.Config, c.Port, c.Interval)
+    return nil
+}
+
+/* checkCmd is "origami check <printer>": one-shot poll of a single printer, for cron/Nagios */
+type checkCmd struct {
+    LowThreshold int `long:"low-threshold" description:"toner percent below which this command exits non-zero; defaults to the printer's low_threshold, or 10 if that isn't set"`
+    Positional   struct {
+        Printer string `positional-arg-name:"printer" description:"name of the printer to check, as it appears in the config file"`
+    } `positional-args:"yes" required:"yes"`
+}
+
+func (c *checkCmd) Execute(args []string) error {
+    rc, err := loadRuntimeConfig(opts.Config)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Could not load %s: %v\n", opts.Config, err)
+        os.Exit(2)
+    }
+    cfg, ok := rc.Printers[c.Positional.Printer]
+    if !ok {
+        fmt.Fprintf(os.Stderr, "No printer named %q in %s\n", c.Positional.Printer, opts.Config)
+        os.Exit(2)
+    }
+
+    toner, cartridge, supplies, alerts, _, err := pollWithRetry(cfg, rc.Search, time.Duration(rc.TimeoutSeconds)*time.Second)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Could not poll %s: %v\n", c.Positional.Printer, err)
+        os.Exit(2)
+    }
+
+    pct := tonerPercent(PrinterData{Toner: toner, Cart: cartridge, Supplies: supplies})
+    fmt.Printf("%s: toner=%d%% cartridge=%s alerts=%d\n", c.Positional.Printer, pct, cartridge, len(alerts))
+    threshold := c.LowThreshold
+    if threshold <= 0 {
+        threshold = cfg.LowThreshold // no --low-threshold given, so use the printer's own
+    }
+    if threshold <= 0 {
+        threshold = defaultLowThreshold
+    }
+    if pct >= 0 && pct < threshold {
+        os.Exit(1) // below threshold: exit non-zero so cron/Nagios can flag it
+    }
+    return nil
+}
+
+/* dumpCmd is "origami dump": poll every printer once and print the resulting state, then exit */
+type dumpCmd struct {
+    Format string `long:"format" choice:"json" choice:"csv" default:"json" description:"output format"`
+}
+
+func (c *dumpCmd) Execute(args []string) error {
+    rc, err := loadRuntimeConfig(opts.Config)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Could not load %s: %v\n", opts.Config, err)
+        os.Exit(2)
+    }
+    keys := sortMap(rc.Printers)
+    pd.Printers = make([]PrinterData, len(keys))
+    for i, name := range keys {
+        pd.Printers[i].Name = name
+    }
+    pollAll(rc.Printers, keys, rc.Search, rc.MaxConcurrency, time.Duration(rc.TimeoutSeconds)*time.Second, nil, rc.FailureThreshold)
+
+    switch c.Format {
+    case "csv":
+        w := csv.NewWriter(os.Stdout)
+        w.Write([]string{"name", "toner", "cartridge", "alerts"})
+        for _, p := range pd.Printers {
+            w.Write([]string{p.Name, p.Toner, p.Cart, fmt.Sprintf("%d", len(p.Alerts))})
+        }
+        w.Flush()
+    default: // "json"
+        enc := json.NewEncoder(os.Stdout)
+        enc.SetIndent("", "  ")
+        enc.Encode(pd.Printers)
+    }
+    return nil
+}
+
+/* validateCmd is "origami validate <config>": lint a config file without starting the server */
+type validateCmd struct {
+    Positional struct {
+        ConfigFile string `positional-arg-name:"config" description:"path of the config file to validate"`
+    } `positional-args:"yes"`
+}
+
+func (c *validateCmd) Execute(args []string) error {
+    filepath := opts.Config
+    if c.Positional.ConfigFile != "" {
+        filepath = c.Positional.ConfigFile
+    }
+
+    cfg, err := config.Load(filepath)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err) // *config.ParseError: identifies the file, line, and/or field at fault
+        os.Exit(1)
+    }
+    fmt.Printf("%s is valid: %d printer(s), %d search selector(s), %d minute interval, port %s\n", filepath, len(cfg.Printers), len(cfg.Search), cfg.IntervalMinutes, cfg.Port)
+    return nil
+}
+
+/*
+    main:       parses cmdline flags and dispatches to the chosen subcommand
+    params:     n/a
+    returns:    void
+*/
+func main() {
+    parser := flags.NewParser(&opts, flags.Default)
+    parser.LongDescription = "Origami checks toner levels and other printer info for printers at Elizabethtown College."
+
+    parser.AddCommand("serve", "Run the web server and poll printers on an interval", "", &serveCmd{})
+    parser.AddCommand("check", "One-shot poll of a single printer, exits non-zero on low toner", "", &checkCmd{})
+    parser.AddCommand("dump", "Print the current state of every printer and exit", "", &dumpCmd{})
+    parser.AddCommand("validate", "Lint a config file without starting the server", "", &validateCmd{})
+
+    if _, err := parser.Parse(); err != nil {
+        if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+            os.Exit(0)
+        }
+        os.Exit(1)
+    }
+}
@@ -0,0 +1,283 @@
+/*********************************************************
+*   package:    config
+*   desc:       typed yaml configuration for origami, with validation and a file-watching hot reloader
+*   files:      config.go
+*   author:     rory dudley (pinecat)
+*********************************************************/
+
+package config
+
+import (
+    "fmt" // for building structured error messages
+    "os" // for reading the config file and watching its directory
+    "path/filepath" // for watching the config file's directory (editors often replace-by-rename)
+    "regexp" // for pulling a line number back out of a yaml error message
+    "strconv" // for parsing that line number
+    "gopkg.in/yaml.v3" // for decoding the config file, and for its line-numbered errors
+    "github.com/fsnotify/fsnotify" // for watching the config file for changes
+)
+
+/* Config is the top-level shape of an origami config file */
+type Config struct {
+    Printers       []PrinterEntry `yaml:"printers"`
+    Search         []string       `yaml:"search"`
+    IntervalMinutes int           `yaml:"interval_minutes"`
+    Port           string         `yaml:"port"`
+    RetentionDays  int            `yaml:"retention_days"`
+    MaxConcurrency int            `yaml:"max_concurrency"`
+    TimeoutSeconds int            `yaml:"timeout_seconds"`
+    Alerts         AlertsConfig   `yaml:"alerts"`
+}
+
+/* PrinterEntry is one printer's configuration */
+type PrinterEntry struct {
+    Name         string        `yaml:"name"`
+    Address      string        `yaml:"address"`
+    Scheme       string        `yaml:"scheme"` // "http", "https", or "snmp"; defaults to "http"
+    Scraper      ScraperConfig `yaml:"scraper"`
+    Credentials  CredsConfig   `yaml:"credentials"`
+    LowThreshold int           `yaml:"low_threshold"`
+    Tags         []string      `yaml:"tags"`
+}
+
+/* ScraperConfig holds per-printer overrides for the html scraper, since not every printer's status page looks the same */
+type ScraperConfig struct {
+    Selectors      []string `yaml:"selectors"`       // overrides the top-level "search" selectors for this printer
+    PercentRegex   string   `yaml:"percent_regex"`   // overrides the default toner-percent regex for this printer
+    CartridgeRegex string   `yaml:"cartridge_regex"` // overrides the default cartridge-type regex for this printer
+}
+
+/* CredsConfig holds the snmp credentials for a printer, v2c or v3 */
+type CredsConfig struct {
+    Version   string `yaml:"version"` // "v2c" or "v3"
+    Community string `yaml:"community"`
+    User      string `yaml:"user"`
+    AuthProto string `yaml:"auth_proto"`
+    AuthPass  string `yaml:"auth_pass"`
+    PrivProto string `yaml:"priv_proto"`
+    PrivPass  string `yaml:"priv_pass"`
+}
+
+/* AlertsConfig configures the alerts subsystem: when to consider a printer "down", how often to remind, and where alerts go */
+type AlertsConfig struct {
+    FailureThreshold     int              `yaml:"failure_threshold"`      // consecutive scrape/poll failures before an alert fires
+    RenotifyAfterMinutes int              `yaml:"renotify_after_minutes"` // how long an unresolved alert stays quiet before firing again
+    StorePath            string           `yaml:"store_path"`             // path to the boltdb file tracking alert history and acknowledgements
+    Notifiers            []NotifierConfig `yaml:"notifiers"`
+}
+
+/* NotifierConfig is one notifier backend, as configured in the yaml */
+type NotifierConfig struct {
+    Type string     `yaml:"type"` // "smtp", "slack", "discord", "teams", "webhook", or "log"
+    URL  string     `yaml:"url"`  // webhook url, used by "slack"/"discord"/"teams"/"webhook"
+    Path string     `yaml:"path"` // log file path, used by "log"
+    SMTP SMTPConfig `yaml:"smtp"` // used by "smtp"
+}
+
+/* SMTPConfig holds the settings needed to send alert emails */
+type SMTPConfig struct {
+    Host     string   `yaml:"host"`
+    Port     int      `yaml:"port"`
+    Username string   `yaml:"username"` // leave empty to send unauthenticated, e.g. to an internal relay
+    Password string   `yaml:"password"`
+    From     string   `yaml:"from"`
+    To       []string `yaml:"to"`
+}
+
+/* ParseError identifies the file, line, and field a config problem came from, instead of a bare log.Fatal */
+type ParseError struct {
+    File  string
+    Line  int
+    Field string
+    Err   error
+}
+
+func (e *ParseError) Error() string {
+    switch {
+    case e.Field != "" && e.Line > 0:
+        return fmt.Sprintf("%s:%d: field %q: %v", e.File, e.Line, e.Field, e.Err)
+    case e.Field != "":
+        return fmt.Sprintf("%s: field %q: %v", e.File, e.Field, e.Err)
+    case e.Line > 0:
+        return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+    default:
+        return fmt.Sprintf("%s: %v", e.File, e.Err)
+    }
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+var yamlLineRegex = regexp.MustCompile(`line (\d+):`) // yaml.v3 embeds "line N:" in its error text
+
+/*
+    Load: reads and validates an origami config file
+    params: path - path to the yaml config file
+    returns: cfg - the parsed and validated config
+             err - a *ParseError identifying the file, line, and/or field at fault
+*/
+func Load(path string) (*Config, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, &ParseError{File: path, Err: err}
+    }
+
+    var cfg Config
+    if err := yaml.Unmarshal(data, &cfg); err != nil {
+        return nil, &ParseError{File: path, Line: lineFromYAMLError(err), Err: err}
+    }
+
+    if err := cfg.applyDefaults().validate(); err != nil {
+        if pe, ok := err.(*ParseError); ok {
+            pe.File = path
+            return nil, pe
+        }
+        return nil, &ParseError{File: path, Err: err}
+    }
+
+    return &cfg, nil
+}
+
+/* lineFromYAMLError pulls the first "line N:" out of a yaml.v3 error, or 0 if there isn't one */
+func lineFromYAMLError(err error) int {
+    m := yamlLineRegex.FindStringSubmatch(err.Error())
+    if m == nil {
+        return 0
+    }
+    line, _ := strconv.Atoi(m[1])
+    return line
+}
+
+/* applyDefaults fills in defaults for the optional fields a config file leaves out */
+func (c *Config) applyDefaults() *Config {
+    if c.RetentionDays == 0 {
+        c.RetentionDays = 30
+    }
+    if c.MaxConcurrency == 0 {
+        c.MaxConcurrency = 4
+    }
+    if c.TimeoutSeconds == 0 {
+        c.TimeoutSeconds = 10
+    }
+    for i := range c.Printers {
+        if c.Printers[i].Scheme == "" {
+            c.Printers[i].Scheme = "http"
+        }
+    }
+    if c.Alerts.FailureThreshold == 0 {
+        c.Alerts.FailureThreshold = 3
+    }
+    if c.Alerts.RenotifyAfterMinutes == 0 {
+        c.Alerts.RenotifyAfterMinutes = 60
+    }
+    if c.Alerts.StorePath == "" {
+        c.Alerts.StorePath = "origami-alerts.db"
+    }
+    return c
+}
+
+/* validate checks the fields that would otherwise blow up later, deep in the poll loop, instead of here up front */
+func (c *Config) validate() error {
+    if c.IntervalMinutes < 1 {
+        return &ParseError{Field: "interval_minutes", Err: fmt.Errorf("must be >= 1")}
+    }
+    if len(c.Printers) == 0 {
+        return &ParseError{Field: "printers", Err: fmt.Errorf("at least one printer is required")}
+    }
+
+    seen := make(map[string]bool, len(c.Printers))
+    for i, p := range c.Printers {
+        field := fmt.Sprintf("printers[%d]", i)
+        if p.Name == "" {
+            return &ParseError{Field: field + ".name", Err: fmt.Errorf("name is required")}
+        }
+        if seen[p.Name] {
+            return &ParseError{Field: field + ".name", Err: fmt.Errorf("duplicate printer name %q", p.Name)}
+        }
+        seen[p.Name] = true
+        if p.Address == "" {
+            return &ParseError{Field: field + ".address", Err: fmt.Errorf("address is required")}
+        }
+        switch p.Scheme {
+        case "http", "https", "snmp":
+        default:
+            return &ParseError{Field: field + ".scheme", Err: fmt.Errorf("unknown scheme %q, must be http, https, or snmp", p.Scheme)}
+        }
+        if p.Scheme == "snmp" && p.Credentials.Version == "v3" && p.Credentials.User == "" {
+            return &ParseError{Field: field + ".credentials.user", Err: fmt.Errorf("user is required for snmp v3")}
+        }
+    }
+
+    for i, n := range c.Alerts.Notifiers {
+        field := fmt.Sprintf("alerts.notifiers[%d]", i)
+        switch n.Type {
+        case "slack", "discord", "teams", "webhook":
+            if n.URL == "" {
+                return &ParseError{Field: field + ".url", Err: fmt.Errorf("url is required for a %q notifier", n.Type)}
+            }
+        case "log":
+            if n.Path == "" {
+                return &ParseError{Field: field + ".path", Err: fmt.Errorf("path is required for a \"log\" notifier")}
+            }
+        case "smtp":
+            if n.SMTP.Host == "" || n.SMTP.From == "" || len(n.SMTP.To) == 0 {
+                return &ParseError{Field: field + ".smtp", Err: fmt.Errorf("host, from, and at least one \"to\" address are required for an \"smtp\" notifier")}
+            }
+        default:
+            return &ParseError{Field: field + ".type", Err: fmt.Errorf("unknown notifier type %q, must be smtp, slack, discord, teams, webhook, or log", n.Type)}
+        }
+    }
+    return nil
+}
+
+/*
+    Watch: watches a config file's directory and calls onReload with the newly-parsed config whenever it
+           changes and still validates; a config that fails to parse or validate is logged and ignored,
+           leaving the previously-loaded config (and onReload's caller) untouched
+    params: path - path to the yaml config file
+            onReload - called with the new config after every successful reload
+    returns: watcher - the underlying fsnotify watcher, so the caller can Close it on shutdown
+             err - non-nil if the watch could not be established
+*/
+func Watch(path string, onReload func(*Config)) (*fsnotify.Watcher, error) {
+    watcher, err := fsnotify.NewWatcher()
+    if err != nil {
+        return nil, err
+    }
+
+    dir := filepath.Dir(path) // watch the directory, not the file: editors commonly replace a file by rename
+    if err := watcher.Add(dir); err != nil {
+        watcher.Close()
+        return nil, err
+    }
+
+    target := filepath.Clean(path)
+    go func() {
+        for {
+            select {
+            case event, ok := <-watcher.Events:
+                if !ok {
+                    return
+                }
+                if filepath.Clean(event.Name) != target {
+                    continue
+                }
+                if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+                    continue
+                }
+                cfg, err := Load(path)
+                if err != nil {
+                    fmt.Fprintf(os.Stderr, "config reload failed, keeping previous config: %v\n", err) // rollback: just don't call onReload
+                    continue
+                }
+                onReload(cfg)
+            case err, ok := <-watcher.Errors:
+                if !ok {
+                    return
+                }
+                fmt.Fprintf(os.Stderr, "config watcher error: %v\n", err)
+            }
+        }
+    }()
+
+    return watcher, nil
+}
@@ -0,0 +1,118 @@
+/*********************************************************
+*   package:    config
+*   desc:       tests for Config.validate's field checks
+*   files:      config_test.go
+*   author:     rory dudley (pinecat)
+*********************************************************/
+
+package config
+
+import (
+    "strings"
+    "testing"
+)
+
+/* validConfig returns a minimal config that passes validate(), for tests to mutate a single field off of */
+func validConfig() *Config {
+    return &Config{
+        IntervalMinutes: 5,
+        Printers: []PrinterEntry{
+            {Name: "lobby", Address: "10.0.0.1", Scheme: "http"},
+        },
+    }
+}
+
+func TestValidateAccepts(t *testing.T) {
+    if err := validConfig().validate(); err != nil {
+        t.Fatalf("validate() on a minimal valid config returned %v", err)
+    }
+}
+
+func TestValidateRejects(t *testing.T) {
+    cases := []struct {
+        name      string
+        mutate    func(*Config)
+        wantField string
+    }{
+        {
+            name:      "interval below 1",
+            mutate:    func(c *Config) { c.IntervalMinutes = 0 },
+            wantField: "interval_minutes",
+        },
+        {
+            name:      "no printers",
+            mutate:    func(c *Config) { c.Printers = nil },
+            wantField: "printers",
+        },
+        {
+            name:      "missing printer name",
+            mutate:    func(c *Config) { c.Printers[0].Name = "" },
+            wantField: "printers[0].name",
+        },
+        {
+            name: "duplicate printer name",
+            mutate: func(c *Config) {
+                c.Printers = append(c.Printers, PrinterEntry{Name: "lobby", Address: "10.0.0.2", Scheme: "http"})
+            },
+            wantField: "printers[1].name",
+        },
+        {
+            name:      "missing address",
+            mutate:    func(c *Config) { c.Printers[0].Address = "" },
+            wantField: "printers[0].address",
+        },
+        {
+            name:      "unknown scheme",
+            mutate:    func(c *Config) { c.Printers[0].Scheme = "ftp" },
+            wantField: "printers[0].scheme",
+        },
+        {
+            name: "snmp v3 missing user",
+            mutate: func(c *Config) {
+                c.Printers[0].Scheme = "snmp"
+                c.Printers[0].Credentials.Version = "v3"
+            },
+            wantField: "printers[0].credentials.user",
+        },
+        {
+            name: "webhook notifier missing url",
+            mutate: func(c *Config) {
+                c.Alerts.Notifiers = []NotifierConfig{{Type: "webhook"}}
+            },
+            wantField: "alerts.notifiers[0].url",
+        },
+        {
+            name: "smtp notifier missing fields",
+            mutate: func(c *Config) {
+                c.Alerts.Notifiers = []NotifierConfig{{Type: "smtp"}}
+            },
+            wantField: "alerts.notifiers[0].smtp",
+        },
+        {
+            name: "unknown notifier type",
+            mutate: func(c *Config) {
+                c.Alerts.Notifiers = []NotifierConfig{{Type: "carrier_pigeon"}}
+            },
+            wantField: "alerts.notifiers[0].type",
+        },
+    }
+
+    for _, c := range cases {
+        t.Run(c.name, func(t *testing.T) {
+            cfg := validConfig()
+            c.mutate(cfg)
+
+            err := cfg.validate()
+            if err == nil {
+                t.Fatalf("validate() = nil, want an error for field %q", c.wantField)
+            }
+            pe, ok := err.(*ParseError)
+            if !ok {
+                t.Fatalf("validate() returned %T, want *ParseError", err)
+            }
+            if !strings.HasPrefix(pe.Field, c.wantField) {
+                t.Errorf("validate() field = %q, want prefix %q", pe.Field, c.wantField)
+            }
+        })
+    }
+}
@@ -0,0 +1,93 @@
+/*********************************************************
+*   program:    origami
+*   desc:       in-process ring buffer of toner history per printer, served over /api/history
+*   files:      history.go
+*   author:     rory dudley (pinecat)
+*********************************************************/
+
+package main
+
+import (
+    "strconv" // for parsing the toner percent out of a "NN%" string
+    "strings" // for trimming the "%" off the toner string
+    "sync" // for guarding the history map across the poll loop and http handlers
+    "time" // for timestamping samples
+)
+
+/* HistorySample is a single point in a printer's toner history */
+type HistorySample struct {
+    Time  string // when the sample was taken
+    Toner int    // toner percent at that time
+}
+
+/* globals */
+var (
+    history          = make(map[string][]HistorySample) // ring buffer of samples, keyed by printer name
+    historyMu        sync.Mutex                          // guards history, since it's read by the http handlers and written by the poll loop
+    historyRetention int                                  // max samples to keep per printer, set via setHistoryRetention from retention_days and the poll interval
+)
+
+/*
+    recordHistory: appends a sample to a printer's ring buffer, trimming the oldest sample if it's over retention
+    params:        name - the printer's display name
+                   toner - the toner percent to record
+    returns:       void
+*/
+func recordHistory(name string, toner int) {
+    historyMu.Lock()
+    defer historyMu.Unlock()
+
+    samples := append(history[name], HistorySample{Time: time.Now().Format("2006-01-02_15:04:05"), Toner: toner}) // append the new sample
+    if len(samples) > historyRetention { // ring buffer is full, so...
+        samples = samples[len(samples)-historyRetention:] // ...drop the oldest sample(s)
+    }
+    history[name] = samples
+}
+
+/*
+    setHistoryRetention: updates how many samples recordHistory keeps per printer, guarded by the
+                         same lock recordHistory reads it under, since it's set both at startup and
+                         from the fsnotify config-reload goroutine while the poll loop is running
+    params:              n - the new retention, in samples
+    returns:             void
+*/
+func setHistoryRetention(n int) {
+    historyMu.Lock()
+    defer historyMu.Unlock()
+    historyRetention = n
+}
+
+/*
+    historyFor: returns a copy of a printer's current history, safe to read outside the lock
+    params:     name - the printer's display name
+    returns:    samples - the printer's history, oldest first
+*/
+func historyFor(name string) []HistorySample {
+    historyMu.Lock()
+    defer historyMu.Unlock()
+
+    samples := make([]HistorySample, len(history[name]))
+    copy(samples, history[name])
+    return samples
+}
+
+/*
+    tonerPercent: pulls the numeric toner percent out of a PrinterData, for both the http-scraped
+                  and snmp-polled cases
+    params:       p - the printer data to inspect
+    returns:      pct - the toner percent, or -1 if it could not be determined
+*/
+func tonerPercent(p PrinterData) int {
+    if p.Toner != "" { // the http-scraped case: toner is already a "NN%" string
+        pct, err := strconv.Atoi(strings.TrimSuffix(p.Toner, "%"))
+        if err == nil {
+            return pct
+        }
+    }
+    for _, s := range p.Supplies { // the snmp case: use the first supply with a computable percent (usually black toner)
+        if s.Percent >= 0 {
+            return s.Percent
+        }
+    }
+    return -1
+}
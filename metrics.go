@@ -0,0 +1,74 @@
+/*********************************************************
+*   program:    origami
+*   desc:       prometheus-format /metrics endpoint and /api/history json endpoint
+*   files:      metrics.go
+*   author:     rory dudley (pinecat)
+*********************************************************/
+
+package main
+
+import (
+    "encoding/json" // for serving history as json
+    "fmt" // for writing the prometheus exposition format
+    "net/http" // for the handlers themselves
+)
+
+/* globals */
+var (
+    scrapeDurationSeconds float64 // how long the last full poll of all printers took
+    scrapeErrorsTotal     int     // running count of failed polls across all printers
+)
+
+/*
+    metricsHandler: serves prometheus-format gauges for toner levels and scrape health
+    params:         w - http response writer
+                    r - http request
+    returns:        void
+*/
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+    pdMu.Lock()
+    printers := append([]PrinterData(nil), pd.Printers...) // copy so rendering doesn't race with the next poll pass
+    duration := scrapeDurationSeconds
+    errorsTotal := scrapeErrorsTotal
+    pdMu.Unlock()
+
+    fmt.Fprintf(w, "# HELP origami_toner_percent Current toner percent remaining for a printer cartridge.\n")
+    fmt.Fprintf(w, "# TYPE origami_toner_percent gauge\n")
+    for _, p := range printers {
+        pct := tonerPercent(p)
+        if pct < 0 { // couldn't parse a percent for this printer, so don't emit a bogus sample
+            continue
+        }
+        fmt.Fprintf(w, "origami_toner_percent{printer=%q,cartridge=%q} %d\n", p.Name, p.Cart, pct)
+    }
+
+    fmt.Fprintf(w, "# HELP origami_printer_up Whether the last poll of a printer succeeded (1) or not (0).\n")
+    fmt.Fprintf(w, "# TYPE origami_printer_up gauge\n")
+    for _, p := range printers {
+        up := 0
+        if p.ConsecutiveFailures == 0 && p.LastError == "" { // stale Toner/Supplies from the last good poll shouldn't count as "up"
+            up = 1
+        }
+        fmt.Fprintf(w, "origami_printer_up{printer=%q} %d\n", p.Name, up)
+    }
+
+    fmt.Fprintf(w, "# HELP origami_scrape_duration_seconds How long the last full poll of all printers took.\n")
+    fmt.Fprintf(w, "# TYPE origami_scrape_duration_seconds gauge\n")
+    fmt.Fprintf(w, "origami_scrape_duration_seconds %f\n", duration)
+
+    fmt.Fprintf(w, "# HELP origami_scrape_errors_total Running count of failed printer polls.\n")
+    fmt.Fprintf(w, "# TYPE origami_scrape_errors_total counter\n")
+    fmt.Fprintf(w, "origami_scrape_errors_total %d\n", errorsTotal)
+}
+
+/*
+    historyHandler: serves a printer's toner history as json, via ?printer=<name>
+    params:         w - http response writer
+                    r - http request
+    returns:        void
+*/
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+    name := r.URL.Query().Get("printer")
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(historyFor(name))
+}
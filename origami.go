@@ -12,19 +12,18 @@ package main
 import (
     "fmt" // for printing out info
     "log" // for logging info
-    "os" // for opening files and getting cmdline args
-    "bufio" // for reading in files
     "time" // for sleeping on an interval and getting the date-time
     "sync" // for syncing go routines
-    "strings" // for parsing (splitting) strings when reading from conf file
+    "strings" // for parsing (splitting) strings
     "sort" // for sorting maps
-    "strconv" // for converting strings to ints and ints to strings
     "regexp" // for parsing out percents and other info using regex
     "net/http" // for routing and get requests
     "crypto/tls" // for ignoring bad ssl certs
-    _"io/ioutil" // for reading text
     "html/template" // html template for the index page
     "github.com/PuerkitoBio/goquery" // for parsing html
+    "github.com/pinecat/origamiv2/alerts" // for firing/tracking low-toner and down alerts
+    "github.com/pinecat/origamiv2/config" // for loading and hot-reloading the yaml config
+    "github.com/pinecat/origamiv2/snmp" // for polling printers over snmp instead of scraping their status page
 )
 
 /* globals */
@@ -33,6 +32,8 @@ var (
     cartridgeRegex *regexp.Regexp // for holding regular expression to parse cartridge type
     wg sync.WaitGroup // for syncing go routines
     pd PageData
+    pdMu sync.Mutex // guards pd.Printers and the scrape stats, written by the poll loop's workers and read by the http handlers
+    alertEngine *alerts.Engine // tracks and notifies on low-toner/down conditions, set up in runServe
     indexPage string = `
         <!DOCTYPE html>
         <head>
@@ -103,6 +104,7 @@ var (
             <div id="heading">
                 <h1>Origami V2</h1>
                 <h4>By Rory Dudley (aka pinecat)</h4><br>
+                <h4><a href="/alerts">Alerts</a></h4>
                 <h4><a href="https://www.gitlab.com/pinecat/origamiv2" target="_blank">Gitlab</a></h4>
             </div>
 
@@ -114,16 +116,20 @@ var (
             <div id="data">
                 <table>
                     <thead>
+                        <th align="center"><h3>Status</h3></th>
                         <th align="center"><h3>Printer Name</h3></th>
                         <th align="center"><h3>Toner Level</h3></th>
                         <th align="center"><h3>Cartridge Type</h3></th>
+                        <th align="center"><h3>History</h3></th>
                     </thead>
 
                     {{ range $index, $data := .Printers }}
                     <tbody>
+                        <td align="center"><span style="display:inline-block;width:10px;height:10px;border-radius:50%;background-color:{{ statusDotColor $data }};"></span></td>
                         <td align="center"><h3><a href="{{ $data.Addr }}" target="_blank">{{ $data.Name }}</a></h3></td>
                         <td align="center"><h3>{{ $data.Toner }}</h3></td>
                         <td align="center"><h3>{{ $data.Cart }}</h3></td>
+                        <td align="center">{{ sparkline $data.Name }}</td>
                     </tbody>
                     {{ end }}
                 </table>
@@ -144,90 +150,46 @@ type PrinterData struct {
     Addr    string
     Toner   string
     Cart    string
+    Supplies     []snmp.Supply // populated when the printer is polled via snmp
+    Alerts       []snmp.Alert  // active prtAlertTable entries (paper jam, out of paper, cover open, etc.)
+    DeviceStatus int           // hrDeviceStatus for the printer device, populated when polled via snmp; 0 for http-scraped printers
+
+    LastSuccess         string // timestamp of the last poll that didn't error, after retries
+    ConsecutiveFailures int    // how many polls in a row have failed, after retries
+    LastError           string // the error from the most recent failed poll, empty if the last poll succeeded
+}
+
+/* PrinterConfig holds everything we need to know to poll a single printer, either via http scraping or snmp */
+type PrinterConfig struct {
+    Address        string          // ip or hostname of the printer
+    Scheme         string          // "http", "https" (scrape the status page) or "snmp" (walk the printer mib)
+    Creds          snmp.Creds      // snmp credentials, only used when Scheme == "snmp"
+    Search         []string        // per-printer selector override; falls back to the top-level search list if empty
+    PercentRegex   *regexp.Regexp  // per-printer toner-percent regex override; falls back to percentRegex if nil
+    CartridgeRegex *regexp.Regexp  // per-printer cartridge-type regex override; falls back to cartridgeRegex if nil
+    LowThreshold   int             // toner percent below which "origami check" and the alerts subsystem consider this printer low
+    Tags           []string        // free-form tags from the config; carried along, but nothing in the poller or the alerts subsystem reads them
 }
 
 /*
-    dispError: checks if there is an error, displays message and error if err is not nil, does not exit program
-    params:     msg - message to display if err is not nil
-                err - the error in question (possibly nil)
-    returns:    void
+    init: compiles the default scraper regexes, so they're ready before any subcommand runs -
+          not just "serve", which used to be the only one that compiled them
 */
-func dispError(msg string, err error) {
-    if err != nil {
-        log.Println("MSG: ", msg, " ERR: ", err)
-    }
+func init() {
+    percentRegex, _ = regexp.Compile(`\d\d%|\d%`) // regular expression for getting toner percents
+    cartridgeRegex, _ = regexp.Compile(`[A-Z0-9]{6}`) // regular expression for getting cartridge type
 }
 
 /*
-    checkError: checks if there is an error, displays message and error if err is not nil, then exits the program
+    dispError: checks if there is an error, displays message and error if err is not nil, does not exit program
     params:     msg - message to display if err is not nil
                 err - the error in question (possibly nil)
     returns:    void
 */
-func checkError(msg string, err error) {
+func dispError(msg string, err error) {
     if err != nil {
-        log.Fatal("MSG: ", msg, " ERR: ", err)
-    }
-}
-
-/*
-    readInPrinters: read in the configuration file for origami (includes printers and their ips, html tags/classes to search for, and the interval to grab data at)
-    params:         filepath - filepath of the configuration file ("origami.conf" by default)
-    returns:        printers - a map of the printers and their ips
-                    search - an array of html tags/classes to search through
-                    interval - the interval at which to collect data (in minutes)
-*/
-func readInPrinters(filepath string) (map[string]string, []string, int, string) {
-    file, err := os.Open(filepath) // open the file specified
-    checkError("Could not read in printer file!", err) // check for error when opening the file
-    defer file.Close() // close the file at the end of the method
-
-    scanner := bufio.NewScanner(file) // create new scanner to read the file
-
-    printers := make(map[string]string) // create a map for printers and their ips
-    var search []string // create array for classes to search
-    var interval int // create int for the interval
-    var port string // create string for the port
-
-    for scanner.Scan() { // keep scanning
-        if scanner.Text() == "[SEARCH]" { // if we get to the next section in the conf file...
-            break // ...break from this loop
-        }
-        if scanner.Text() != "[PRINTERS]" && scanner.Text() != "\n" && scanner.Text() != "" { // don't pickup uneeded text
-            s := strings.Split(scanner.Text(), "=") // otherwise, split the string
-            printers[s[0]] = s[1] // then add them to the map
-        }
-    }
-
-    for scanner.Scan() { // keep scanning
-        if scanner.Text() == "[INTERVAL]" { // if we get to the next section in the conf file...
-            break // ...break from this loop
-        }
-        if scanner.Text() != "[SEARCH]" && scanner.Text() != "\n" && scanner.Text() != "" { // don't pickup uneeded text
-            search = append(search, scanner.Text()) // add to the search array
-        }
-    }
-
-    for scanner.Scan() { // keep scanning
-        if scanner.Text() == "[PORT]" { // if we get to the next section in the conf file...
-            break // ...break from this loop
-        }
-        if scanner.Text() != "[INTERVAL]" && scanner.Text() != "\n" && scanner.Text() != "" { // don't pickup uneeded text
-            interval, err = strconv.Atoi(strings.Split(scanner.Text(), "=")[1]) // split the string and set the interval
-            checkError("Invalid interval in configuration file!", err) // check for errors with the specified interval
-            if interval < 1 { // interval cannot be less than 1, so...
-                log.Fatal("MSG: Interval may not be less than 1 minute!\n") // log a fatal error if it is less than 1, and exit the program
-            }
-        }
-    }
-
-    for scanner.Scan() { // keep scanning
-        if scanner.Text() != "[PORT]" && scanner.Text() != "\n" && scanner.Text() != "" { // don't pickup uneeded text
-            port = ":" + strings.Split(scanner.Text(), "=")[1] // get the port
-        }
+        log.Println("MSG: ", msg, " ERR: ", err)
     }
-
-    return printers, search, interval, port // return values
 }
 
 /*
@@ -235,7 +197,7 @@ func readInPrinters(filepath string) (map[string]string, []string, int, string)
     params:     m - the map to be sorted
     returns:    keys - the slice of string keys of the map
 */
-func sortMap(m map[string]string) []string {
+func sortMap(m map[string]PrinterConfig) []string {
     keys := make([]string, 0)
     for name, _ := range m {
         keys = append(keys, name)
@@ -245,34 +207,108 @@ func sortMap(m map[string]string) []string {
 }
 
 /*
-    getPrinterData: gets printer data from web page and parses it
-    params:         ip - ip address of printer
-                    search - the array of html tags/classes to query through
-    returns:        toner - percent of remaining toner
-                    cartridge - the printer cartridge type
+    getPrinterDataHTTP: gets printer data by scraping the printer's status page, the original (and still brittle) way
+    params:             client - the http.Client to fetch the status page with
+                        cfg - the printer's config (address, scheme, and scraper overrides)
+                        defaultSearch - the array of html tags/classes to query through, unless cfg overrides it
+    returns:            toner - percent of remaining toner (via *toner)
+                        cartridge - the printer cartridge type (via *cartridge)
+                        err - non-nil if the status page could not be fetched or parsed
 */
-func getPrinterData(ip string, search []string, toner *string, cartridge *string) {
-    resp, err := http.Get("http://" + ip) // get the html of the printer status page
-    dispError("Could not access printer status page!", err) // display error if we could not access the page
+func getPrinterDataHTTP(client *http.Client, cfg PrinterConfig, defaultSearch []string, toner *string, cartridge *string) error {
+    resp, err := client.Get(cfg.Scheme + "://" + cfg.Address) // get the html of the printer status page
+    if err != nil {
+        return fmt.Errorf("could not access printer status page: %w", err)
+    }
     defer resp.Body.Close() // close response body at the end or exit of this function
 
     doc, err := goquery.NewDocumentFromResponse(resp) // generate document from the http response to parse through
-    dispError("Could not create query-able document!", err) // display error if we could not create the document
+    if err != nil {
+        return fmt.Errorf("could not create query-able document: %w", err)
+    }
 
-    //var toner string // string to hold toner percent
-    //var cartridge string // string to hold cartridge type
+    search := defaultSearch
+    if len(cfg.Search) > 0 { // this printer overrides the top-level selectors
+        search = cfg.Search
+    }
+    percentRe, cartridgeRe := percentRegex, cartridgeRegex
+    if cfg.PercentRegex != nil {
+        percentRe = cfg.PercentRegex
+    }
+    if cfg.CartridgeRegex != nil {
+        cartridgeRe = cfg.CartridgeRegex
+    }
 
     var block string
     for _, name := range search {
         doc.Find(name).EachWithBreak(func(i int, s *goquery.Selection) bool{
             block, _ = s.Html()
-            *toner = percentRegex.FindString(block) // parse the text to find the toner percent, and update toner
-            *cartridge = cartridgeRegex.FindString(block) // parse the text to find the cartridge type, and update cartridge
+            *toner = percentRe.FindString(block) // parse the text to find the toner percent, and update toner
+            *cartridge = cartridgeRe.FindString(block) // parse the text to find the cartridge type, and update cartridge
             return false
         })
     }
+    return nil
+}
 
+/*
+    primarySupply: picks the supply to show in the index table's Toner/Cartridge columns for an
+                   snmp-polled printer: the first supply with a computable percent (usually black toner)
+    params:        supplies - every marker supply collected for the printer
+    returns:       toner - the supply's percent, as a "NN%" string, or "" if none could be computed
+                   cartridge - that supply's description, e.g. "Black Toner Cartridge"
+*/
+func primarySupply(supplies []snmp.Supply) (toner string, cartridge string) {
+    for _, s := range supplies {
+        if s.Percent >= 0 {
+            return fmt.Sprintf("%d%%", s.Percent), s.Name
+        }
+    }
+    return "", ""
+}
 
+/*
+    getPrinterDataSNMP: gets printer data by walking the printer mib and host resources mib over snmp
+    params:             cfg - the printer's config (address and snmp credentials)
+                        timeout - how long to wait for the printer to respond
+    returns:            toner, cartridge - the primary supply's percent and description (via *toner, *cartridge)
+                        supplies - the printer's marker supplies (via *supplies)
+                        alerts - the printer's active alert conditions (via *alerts)
+                        deviceStatus - the printer device's hrDeviceStatus (via *deviceStatus)
+                        err - non-nil if the printer could not be polled
+*/
+func getPrinterDataSNMP(cfg PrinterConfig, timeout time.Duration, toner *string, cartridge *string, supplies *[]snmp.Supply, alerts *[]snmp.Alert, deviceStatus *int) error {
+    res, err := snmp.Poll(cfg.Address, cfg.Creds, timeout) // walk the printer over snmp
+    if err != nil {
+        return fmt.Errorf("could not poll printer over snmp: %w", err)
+    }
+    *supplies = res.Supplies // update supplies
+    *alerts = res.Alerts // update alerts
+    *deviceStatus = res.DeviceStatus // update device status
+    *toner, *cartridge = primarySupply(res.Supplies) // surface a percent into the same columns the http-scraped case uses
+    return nil
+}
+
+/*
+    getPrinterData: gets printer data, dispatching to either http scraping or snmp depending on the printer's scheme
+    params:         cfg - the printer's config (address, scheme, and snmp credentials)
+                    search - the array of html tags/classes to query through (only used for the http scheme)
+                    client - the http.Client to use for the http scheme, already configured with a timeout
+                    timeout - how long to wait for the printer to respond, for the snmp scheme
+    returns:        toner - percent of remaining toner (via *toner; for the snmp scheme, the primary supply's percent)
+                    cartridge - the printer cartridge type (via *cartridge; for the snmp scheme, the primary supply's description)
+                    supplies - the printer's marker supplies (via *supplies, only set for the snmp scheme)
+                    alerts - the printer's active alert conditions (via *alerts, only set for the snmp scheme)
+                    deviceStatus - the printer device's hrDeviceStatus (via *deviceStatus, only set for the snmp scheme)
+                    err - non-nil if the printer could not be reached
+*/
+func getPrinterData(cfg PrinterConfig, defaultSearch []string, client *http.Client, timeout time.Duration, toner *string, cartridge *string, supplies *[]snmp.Supply, alerts *[]snmp.Alert, deviceStatus *int) error {
+    switch cfg.Scheme {
+    case "snmp":
+        return getPrinterDataSNMP(cfg, timeout, toner, cartridge, supplies, alerts, deviceStatus)
+    default: // "http" or "https"
+        return getPrinterDataHTTP(client, cfg, defaultSearch, toner, cartridge)
+    }
 }
 
 /*
@@ -282,73 +318,144 @@ func getPrinterData(ip string, search []string, toner *string, cartridge *string
     returns:        void
 */
 func indexHandler(w http.ResponseWriter, r *http.Request) {
-    t, _ := template.New("webpage").Parse(indexPage) // parse embeded index page
-    t.Execute(w, pd) // serve the index page (html template)
-}
+    pdMu.Lock()
+    snapshot := pd
+    snapshot.Printers = append([]PrinterData(nil), pd.Printers...) // copy so rendering doesn't race with the next poll pass
+    pdMu.Unlock()
 
-/*
-    help:       prints a help menu
-    params:     n/a
-    returns:    void
-*/
-func help() {
-    fmt.Printf("ORIGAMI\n")
-    fmt.Printf("\tA web app that checks the toner levels of printers at the Elizabethtown College campus.\n\n")
-    fmt.Printf("USAGE\n")
-    fmt.Printf("\tUsage: origami [-f filepath | -h]\n\n")
-    fmt.Printf("OPTIONS\n")
-    fmt.Printf("\t-f: specify the filepath of the config file (\"./origami.conf\" by default)\n")
-    fmt.Printf("\t-h: this menu\n\n")
-    fmt.Printf("AUTHOR\n")
-    fmt.Printf("\tRory Dudley (aka pinecat: https://github.com/pinecat/origamiv2)\n\n")
-    fmt.Printf("EOF\n")
+    t, _ := template.New("webpage").Funcs(template.FuncMap{"sparkline": sparkline, "statusDotColor": statusDotColor}).Parse(indexPage) // parse embeded index page
+    t.Execute(w, snapshot) // serve the index page (html template)
 }
 
 /*
-    main:       main function of the program
-    params:     n/a
+    runServe:   runs the web server and polls printers on an interval, forever (this was the old, and only, behavior of main)
+    params:     filepath - filepath of the configuration file
+                portOverride - if non-empty, overrides the port read from the config file
+                intervalOverride - if non-zero, overrides the interval (in minutes) read from the config file
     returns:    void
 */
-func main() {
-    filepath := "origami.conf" // setup default filepath for reading configuration file
-    if len(os.Args) == 3 && os.Args[1] == "-f" { // read in different filepath if specified by user at cmdline
-        filepath = os.Args[2] // update the filepath
-    } else if len(os.Args) > 1 && os.Args[1] == "-f" { // if format for -f flag is not correct...
-        fmt.Printf("Usage: %s [-f filepath | -h]\n", os.Args[0]) // print a usage message
-        return // and exit the program
-    } else if len(os.Args) > 1 && os.Args[1] == "-h" { // if flag is -h...
-        help() // ...print a help menu
-        return // and exit the program
+func runServe(filepath string, portOverride string, intervalOverride int) {
+    rc, err := loadRuntimeConfig(filepath)
+    if err != nil {
+        log.Fatalf("Could not load configuration: %v", err)
     }
+    if portOverride != "" {
+        rc.Port = ":" + strings.TrimPrefix(portOverride, ":") // --port/ORIGAMI_PORT wins over the config file
+    }
+    if intervalOverride > 0 {
+        rc.Interval = intervalOverride // --interval/ORIGAMI_INTERVAL wins over the config file
+    }
+    setRuntimeConfig(rc)
 
-    printers, search, interval, port := readInPrinters(filepath) // read in information from configuration file
-    keys := sortMap(printers) // generate sorted string key slice of the printers map
+    keys := sortMap(rc.Printers) // generate sorted string key slice of the printers map
     pd.Printers = make([]PrinterData, len(keys)) // initialize PrinterData array for our page data
+    for i, name := range keys {
+        pd.Printers[i].Name = name
+    }
     http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true} // tell http get requests to ignore bad ssl certs
-    percentRegex, _ = regexp.Compile(`\d\d%|\d%`) // regular expression for getting toner percents
-    cartridgeRegex, _ = regexp.Compile(`[A-Z0-9]{6}`) // regular expression for getting cartridge type
-    _ = interval
+    setHistoryRetention((rc.RetentionDays * 24 * 60) / rc.Interval) // e.g. 30 days at a 5 minute interval is 8640 samples
+
+    alertStore, err := alerts.OpenStore(rc.AlertsStorePath)
+    if err != nil {
+        dispError("Could not open alert store, alerts will not persist across restarts!", err)
+    } else {
+        defer alertStore.Close()
+    }
+    alertEngine = alerts.NewEngine(alertStore, buildNotifiers(rc.NotifierConfigs), rc.RenotifyAfter)
+
+    watcher, err := config.Watch(filepath, func(newCfg *config.Config) {
+        newRC := toRuntimeConfig(newCfg)
+        configPort := newRC.Port // the port the reloaded file asks for, before the live port is put back
+        if portOverride == "" { // the listener is already bound, so keep serving on the current port
+            newRC.Port = rc.Port
+        } else { // cmdline/env overrides still win after a reload
+            newRC.Port = ":" + strings.TrimPrefix(portOverride, ":")
+        }
+        if intervalOverride > 0 {
+            newRC.Interval = intervalOverride
+        }
+        setHistoryRetention((newRC.RetentionDays * 24 * 60) / newRC.Interval)
+        alertEngine.SetNotifiers(buildNotifiers(newRC.NotifierConfigs), newRC.RenotifyAfter)
+        setRuntimeConfig(newRC)
+        if portOverride == "" && configPort != rc.Port {
+            log.Printf("Config reloaded, but the port changed from %s to %s: restart origami to pick that up.", rc.Port, configPort)
+        } else {
+            log.Printf("Config reloaded from %s.", filepath)
+        }
+    })
+    if err != nil {
+        dispError("Could not watch config file for changes, hot reload disabled!", err)
+    } else {
+        defer watcher.Close()
+    }
 
     http.HandleFunc("/", indexHandler) // handle the index page
-    go http.ListenAndServe(port, nil) // start the web server
-    log.Printf("Server started on port %s!\n", strings.Split(port, ":")[1])
+    http.HandleFunc("/metrics", metricsHandler) // handle the prometheus metrics endpoint
+    http.HandleFunc("/api/history", historyHandler) // handle the json history endpoint
+    http.HandleFunc("/alerts", alertsHandler) // handle the alerts page
+    http.HandleFunc("/alerts/ack", alertsAckHandler) // handle acknowledging an alert from the alerts page
+    go http.ListenAndServe(rc.Port, nil) // start the web server
+    log.Printf("Server started on port %s!\n", strings.Split(rc.Port, ":")[1])
+
+    for {
+        cur := getRuntimeConfig() // re-read in case a hot reload swapped it out since the last pass
+        keys := sortMap(cur.Printers)
+        growPrinterData(keys)
+
+        scrapeStart := time.Now() // for timing this whole polling pass
+        pollAll(cur.Printers, keys, cur.Search, cur.MaxConcurrency, time.Duration(cur.TimeoutSeconds)*time.Second, alertEngine, cur.FailureThreshold) // poll every printer concurrently, instead of stalling the whole interval on one hung printer
+
+        pdMu.Lock()
+        scrapeDurationSeconds = time.Since(scrapeStart).Seconds() // record how long this pass took
+        pd.Last = time.Now().Format("2006-01-02_15:04:05") // update when the last check was
+        pd.Next = time.Now().Add(time.Minute * time.Duration(cur.Interval)).Format("2006-01-02_15:04:05") // calculate when the next check will be
+        pdMu.Unlock()
+
+        time.Sleep(time.Minute * time.Duration(cur.Interval)) // sleep for the interval specified
+    }
+}
+
+/*
+    growPrinterData: keeps pd.Printers in step with the current set of printer names, so a hot-reloaded
+                      config that adds, removes, or swaps printers (even while keeping the same count)
+                      doesn't panic on an out-of-range index or leave a stale Name against the wrong
+                      printer's data
+    params:           keys - the current sorted printer names
+    returns:          void
+*/
+func growPrinterData(keys []string) {
+    pdMu.Lock()
+    defer pdMu.Unlock()
 
+    if printerNamesMatch(keys, pd.Printers) {
+        return
+    }
+
+    existing := make(map[string]PrinterData, len(pd.Printers))
+    for _, p := range pd.Printers {
+        existing[p.Name] = p
+    }
+
+    fresh := make([]PrinterData, len(keys))
     for i, name := range keys {
-        pd.Printers[i].Name = name
+        if p, ok := existing[name]; ok {
+            fresh[i] = p // printer survived the reload, so keep its status/history intact
+        } else {
+            fresh[i].Name = name
+        }
     }
+    pd.Printers = fresh
+}
 
-    for {
-        for i, name := range keys { // for all the printers in our map
-            var toner string // variable to hold toner percent
-            var cartridge string // variable to hold cartridge type
-            getPrinterData(printers[name], search, &toner, &cartridge) // run a go routine
-
-            pd.Printers[i].Addr = "http://" + printers[name] // set address of printer via ip address
-            pd.Printers[i].Toner = toner // set toner
-            pd.Printers[i].Cart = cartridge // set cartridge type
+/* printerNamesMatch reports whether keys (sorted printer names) already matches pd.Printers 1:1, in order */
+func printerNamesMatch(keys []string, printers []PrinterData) bool {
+    if len(keys) != len(printers) {
+        return false
+    }
+    for i, name := range keys {
+        if printers[i].Name != name {
+            return false
         }
-        pd.Last = time.Now().Format("2006-01-02_15:04:05") // update when the last check was
-        pd.Next = time.Now().Add(time.Minute * time.Duration(interval)).Format("2006-01-02_15:04:05") // calculate when the next check will be
-        time.Sleep(time.Minute * time.Duration(interval)) // sleep for the interval specified
     }
+    return true
 }
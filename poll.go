@@ -0,0 +1,181 @@
+/*********************************************************
+*   program:    origami
+*   desc:       concurrent polling of all printers, with retries and per-printer status tracking
+*   files:      poll.go
+*   author:     rory dudley (pinecat)
+*********************************************************/
+
+package main
+
+import (
+    "fmt" // for building alert messages
+    "html/template" // for marking the status dot's color as safe to embed unescaped
+    "net/http" // for the per-printer http.Client
+    "strings" // for joining multiple prtAlertTable descriptions into one message
+    "time" // for timeouts, backoff, and timestamping
+    "github.com/pinecat/origamiv2/alerts" // for evaluating low-toner/down/device-error conditions after each poll
+    "github.com/pinecat/origamiv2/snmp" // for snmp.Supply / snmp.Alert / snmp.DeviceStatus*
+)
+
+const (
+    maxRetries    = 3                      // how many times to retry a printer before giving up for this poll
+    retryBackoff  = 2 * time.Second        // base backoff between retries, doubled each attempt
+)
+
+/*
+    pollWithRetry: polls a single printer, retrying with exponential backoff on failure
+    params:        cfg - the printer's config
+                   search - the array of html tags/classes to query through (only used for the http scheme)
+                   timeout - how long to wait on each attempt before giving up
+    returns:       toner, cartridge, supplies, alerts, deviceStatus - whatever was collected on the last attempt
+                   err - the error from the last attempt, nil if it eventually succeeded
+*/
+func pollWithRetry(cfg PrinterConfig, search []string, timeout time.Duration) (toner string, cartridge string, supplies []snmp.Supply, alerts []snmp.Alert, deviceStatus int, err error) {
+    client := &http.Client{Timeout: timeout} // each printer gets its own client, so one slow printer can't affect another's deadline
+
+    backoff := retryBackoff
+    for attempt := 0; attempt <= maxRetries; attempt++ {
+        err = getPrinterData(cfg, search, client, timeout, &toner, &cartridge, &supplies, &alerts, &deviceStatus)
+        if err == nil {
+            return
+        }
+        if attempt < maxRetries { // don't sleep after the last attempt
+            time.Sleep(backoff)
+            backoff *= 2
+        }
+    }
+    return
+}
+
+/*
+    pollAll: polls every printer concurrently, bounded by maxConcurrency, and updates pd.Printers in place
+    params:  printers - map of printer name to config
+             keys - the sorted printer names, indexing into pd.Printers
+             search - the array of html tags/classes to query through (only used for the http scheme)
+             maxConcurrency - how many printers may be polled at once
+             timeout - how long to wait on a single printer attempt before giving up
+             alertEngine - evaluated for low-toner/down conditions after each printer is polled, may be nil
+             failureThreshold - consecutive failures before a printer is considered "down"
+    returns: void
+*/
+func pollAll(printers map[string]PrinterConfig, keys []string, search []string, maxConcurrency int, timeout time.Duration, alertEngine *alerts.Engine, failureThreshold int) {
+    sem := make(chan struct{}, maxConcurrency) // bounds how many goroutines are actually polling at once
+
+    for i, name := range keys {
+        wg.Add(1)
+        go func(i int, name string) {
+            defer wg.Done()
+            sem <- struct{}{}
+            defer func() { <-sem }()
+
+            cfg := printers[name]
+            toner, cartridge, supplies, snmpAlerts, deviceStatus, err := pollWithRetry(cfg, search, timeout)
+
+            pdMu.Lock()
+            pd.Printers[i].Addr = "http://" + cfg.Address // set address of printer via ip address
+            pd.Printers[i].Toner = toner // set toner
+            pd.Printers[i].Cart = cartridge // set cartridge type
+            pd.Printers[i].Supplies = supplies // set snmp supplies, if any
+            pd.Printers[i].Alerts = snmpAlerts // set snmp alerts, if any
+            pd.Printers[i].DeviceStatus = deviceStatus // set snmp device status, if any
+
+            if err != nil {
+                pd.Printers[i].ConsecutiveFailures++
+                pd.Printers[i].LastError = err.Error()
+                scrapeErrorsTotal++
+                p := pd.Printers[i] // snapshot for evaluateAlerts, so it can run outside the lock
+                pdMu.Unlock()
+                evaluateAlerts(alertEngine, cfg, p, failureThreshold)
+                return
+            }
+
+            pd.Printers[i].ConsecutiveFailures = 0
+            pd.Printers[i].LastError = ""
+            pd.Printers[i].LastSuccess = time.Now().Format("2006-01-02_15:04:05")
+            p := pd.Printers[i] // snapshot for tonerPercent/recordHistory/evaluateAlerts, so they can run outside the lock
+            pdMu.Unlock()
+
+            if pct := tonerPercent(p); pct >= 0 { // only record a sample if we actually got a reading
+                recordHistory(name, pct)
+            }
+            evaluateAlerts(alertEngine, cfg, p, failureThreshold)
+        }(i, name)
+    }
+
+    wg.Wait() // block until every printer in this pass has been polled
+}
+
+/*
+    evaluateAlerts: checks a just-polled printer for the down, low-toner, and device-error conditions,
+                     letting the alert engine decide whether that's new enough (or old enough) to notify on
+    params:         alertEngine - the engine to evaluate against, a no-op if nil
+                    cfg - the printer's config, for its low-toner threshold
+                    p - the printer's freshly-updated data
+                    failureThreshold - consecutive failures before a printer is considered "down"
+    returns:        void
+*/
+func evaluateAlerts(alertEngine *alerts.Engine, cfg PrinterConfig, p PrinterData, failureThreshold int) {
+    if alertEngine == nil {
+        return
+    }
+
+    down := p.ConsecutiveFailures >= failureThreshold
+    downMsg := ""
+    if down {
+        downMsg = fmt.Sprintf("%d consecutive failures, last error: %s", p.ConsecutiveFailures, p.LastError)
+    }
+    alertEngine.Evaluate(p.Name, alerts.KindDown, down, downMsg)
+
+    threshold := cfg.LowThreshold
+    if threshold <= 0 {
+        threshold = defaultLowThreshold
+    }
+    pct := tonerPercent(p)
+    low := pct >= 0 && pct < threshold
+    lowMsg := ""
+    if low {
+        lowMsg = fmt.Sprintf("toner at %d%%, below the %d%% threshold", pct, threshold)
+    }
+    alertEngine.Evaluate(p.Name, alerts.KindLowToner, low, lowMsg)
+
+    deviceError, deviceMsg := deviceErrorState(p)
+    alertEngine.Evaluate(p.Name, alerts.KindDeviceError, deviceError, deviceMsg)
+}
+
+/*
+    deviceErrorState: reports whether a printer is currently reporting an snmp error condition -
+                       an active prtAlertTable entry (paper jam, out of paper, cover open) or a
+                       non-running hrDeviceStatus (warning or down) - and a message describing why
+    params:           p - the printer's freshly-updated data
+    returns:          active - true if the printer is currently in an error condition
+                      msg - human-readable detail, empty when active is false
+*/
+func deviceErrorState(p PrinterData) (active bool, msg string) {
+    if len(p.Alerts) > 0 {
+        descriptions := make([]string, 0, len(p.Alerts))
+        for _, a := range p.Alerts {
+            descriptions = append(descriptions, a.Description)
+        }
+        return true, strings.Join(descriptions, "; ")
+    }
+    if p.DeviceStatus == snmp.DeviceStatusWarning || p.DeviceStatus == snmp.DeviceStatusDown {
+        return true, fmt.Sprintf("hrDeviceStatus = %d", p.DeviceStatus)
+    }
+    return false, ""
+}
+
+/*
+    statusDotColor: picks a status dot color for a printer based on its consecutive failure count
+    params:         p - the printer data to inspect
+    returns:        color - a css color, for use in the index template
+*/
+func statusDotColor(p PrinterData) template.HTML {
+    switch {
+    case p.ConsecutiveFailures == 0:
+        return "#3FAE49" // green: last poll succeeded
+    case p.ConsecutiveFailures < 3:
+        return "#E0A030" // yellow: failing, but not for long yet
+    default:
+        return "#C65959" // red: down
+    }
+}
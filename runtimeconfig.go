@@ -0,0 +1,172 @@
+/*********************************************************
+*   program:    origami
+*   desc:       converts the typed yaml config into the runtime shapes the poller and web ui use,
+*               and holds the live config so it can be hot-reloaded without restarting the server
+*   files:      runtimeconfig.go
+*   author:     rory dudley (pinecat)
+*********************************************************/
+
+package main
+
+import (
+    "log" // for warning about a bad regex override without aborting the reload
+    "regexp" // for compiling per-printer scraper regex overrides
+    "strings" // for normalizing the port
+    "sync" // for guarding the live runtime config across the poll loop and the file watcher
+    "time" // for converting the configured renotify window into a time.Duration
+    "github.com/pinecat/origamiv2/alerts" // for building the notifiers the alerts engine fires through
+    "github.com/pinecat/origamiv2/config" // for the typed config this is converted from
+    "github.com/pinecat/origamiv2/snmp" // for snmp.Creds
+)
+
+/* RuntimeConfig is everything the poll loop and web ui need, derived from a config.Config */
+type RuntimeConfig struct {
+    Printers         map[string]PrinterConfig
+    Search           []string
+    Interval         int
+    Port             string
+    RetentionDays    int
+    MaxConcurrency   int
+    TimeoutSeconds   int
+    FailureThreshold int
+    RenotifyAfter    time.Duration
+    AlertsStorePath  string
+    NotifierConfigs  []config.NotifierConfig
+}
+
+/* globals */
+var (
+    runtimeMu  sync.RWMutex
+    runtimeCfg *RuntimeConfig
+)
+
+/*
+    setRuntimeConfig: swaps in a new RuntimeConfig, for the initial load and every hot reload thereafter
+    params:           rc - the new runtime config
+    returns:          void
+*/
+func setRuntimeConfig(rc *RuntimeConfig) {
+    runtimeMu.Lock()
+    defer runtimeMu.Unlock()
+    runtimeCfg = rc
+}
+
+/*
+    getRuntimeConfig: returns the currently-live RuntimeConfig
+    params:           n/a
+    returns:          rc - the current runtime config
+*/
+func getRuntimeConfig() *RuntimeConfig {
+    runtimeMu.RLock()
+    defer runtimeMu.RUnlock()
+    return runtimeCfg
+}
+
+/*
+    loadRuntimeConfig: loads and validates a config file, and converts it into a RuntimeConfig
+    params:            filepath - path to the yaml config file
+    returns:           rc - the converted runtime config
+                       err - a *config.ParseError if the file could not be loaded or failed validation
+*/
+func loadRuntimeConfig(filepath string) (*RuntimeConfig, error) {
+    cfg, err := config.Load(filepath)
+    if err != nil {
+        return nil, err
+    }
+    return toRuntimeConfig(cfg), nil
+}
+
+/*
+    toRuntimeConfig: converts a validated config.Config into the PrinterConfig map and related
+                     settings the rest of the program works with
+    params:          cfg - the parsed config
+    returns:         rc - the converted runtime config
+*/
+func toRuntimeConfig(cfg *config.Config) *RuntimeConfig {
+    printers := make(map[string]PrinterConfig, len(cfg.Printers))
+    for _, p := range cfg.Printers {
+        printers[p.Name] = PrinterConfig{
+            Address:        p.Address,
+            Scheme:         p.Scheme,
+            Creds:          toSNMPCreds(p.Credentials),
+            Search:         p.Scraper.Selectors,
+            PercentRegex:   compileOverride(p.Scraper.PercentRegex),
+            CartridgeRegex: compileOverride(p.Scraper.CartridgeRegex),
+            LowThreshold:   p.LowThreshold,
+            Tags:           p.Tags,
+        }
+    }
+
+    return &RuntimeConfig{
+        Printers:         printers,
+        Search:           cfg.Search,
+        Interval:         cfg.IntervalMinutes,
+        Port:             ":" + strings.TrimPrefix(cfg.Port, ":"),
+        RetentionDays:    cfg.RetentionDays,
+        MaxConcurrency:   cfg.MaxConcurrency,
+        TimeoutSeconds:   cfg.TimeoutSeconds,
+        FailureThreshold: cfg.Alerts.FailureThreshold,
+        RenotifyAfter:    time.Duration(cfg.Alerts.RenotifyAfterMinutes) * time.Minute,
+        AlertsStorePath:  cfg.Alerts.StorePath,
+        NotifierConfigs:  cfg.Alerts.Notifiers,
+    }
+}
+
+/* toSNMPCreds converts the yaml credentials block into the snmp package's Creds */
+func toSNMPCreds(c config.CredsConfig) snmp.Creds {
+    return snmp.Creds{
+        Version:   c.Version,
+        Community: c.Community,
+        User:      c.User,
+        AuthProto: c.AuthProto,
+        AuthPass:  c.AuthPass,
+        PrivProto: c.PrivProto,
+        PrivPass:  c.PrivPass,
+    }
+}
+
+/*
+    buildNotifiers: converts the configured notifier list into the alerts package's Notifier backends
+    params:         configs - the notifier config entries, already validated by config.Load
+    returns:        notifiers - one Notifier per entry, in config order
+*/
+func buildNotifiers(configs []config.NotifierConfig) []alerts.Notifier {
+    notifiers := make([]alerts.Notifier, 0, len(configs))
+    for _, n := range configs {
+        switch n.Type {
+        case "slack":
+            notifiers = append(notifiers, &alerts.WebhookNotifier{URL: n.URL, Format: "slack"})
+        case "discord":
+            notifiers = append(notifiers, &alerts.WebhookNotifier{URL: n.URL, Format: "discord"})
+        case "teams":
+            notifiers = append(notifiers, &alerts.WebhookNotifier{URL: n.URL, Format: "teams"})
+        case "webhook":
+            notifiers = append(notifiers, &alerts.WebhookNotifier{URL: n.URL, Format: "generic"})
+        case "log":
+            notifiers = append(notifiers, &alerts.LogFileNotifier{Path: n.Path})
+        case "smtp":
+            notifiers = append(notifiers, &alerts.SMTPNotifier{
+                Host:     n.SMTP.Host,
+                Port:     n.SMTP.Port,
+                Username: n.SMTP.Username,
+                Password: n.SMTP.Password,
+                From:     n.SMTP.From,
+                To:       n.SMTP.To,
+            })
+        }
+    }
+    return notifiers
+}
+
+/* compileOverride compiles a per-printer regex override, returning nil (meaning "use the default") if it's empty or invalid */
+func compileOverride(pattern string) *regexp.Regexp {
+    if pattern == "" {
+        return nil
+    }
+    re, err := regexp.Compile(pattern)
+    if err != nil {
+        log.Printf("invalid regex override %q, falling back to the default: %v", pattern, err)
+        return nil
+    }
+    return re
+}
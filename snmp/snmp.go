@@ -0,0 +1,385 @@
+/*********************************************************
+*   package:    snmp
+*   desc:       collects printer supply levels and alert conditions via SNMP,
+*               using the Printer MIB (RFC 3805) and Host Resources MIB
+*   files:      snmp.go
+*   author:     rory dudley (pinecat)
+*********************************************************/
+
+package snmp
+
+import (
+    "fmt" // for formatting oid suffixes
+    "strings" // for trimming null-terminated snmp strings
+    "time" // for per-poll timeouts
+    "github.com/gosnmp/gosnmp" // for talking snmp to the printers
+)
+
+/* oids we need out of the printer mib (rfc 3805) and host resources mib */
+const (
+    oidPrtMarkerSuppliesDescription   = ".1.3.6.1.2.1.43.11.1.1.6"  // prtMarkerSuppliesDescription
+    oidPrtMarkerSuppliesLevel         = ".1.3.6.1.2.1.43.11.1.1.9"  // prtMarkerSuppliesLevel
+    oidPrtMarkerSuppliesMaxCapacity   = ".1.3.6.1.2.1.43.11.1.1.8"  // prtMarkerSuppliesMaxCapacity
+    oidPrtMarkerSuppliesColorantIndex = ".1.3.6.1.2.1.43.11.1.1.4"  // prtMarkerSuppliesColorantIndex
+    oidPrtMarkerColorantValue         = ".1.3.6.1.2.1.43.12.1.1.4"  // prtMarkerColorantValue
+    oidHrDeviceType                   = ".1.3.6.1.2.1.25.3.2.1.2"   // hrDeviceType
+    oidHrDeviceStatus                 = ".1.3.6.1.2.1.25.3.2.1.5"   // hrDeviceStatus
+    oidPrtAlertCode                   = ".1.3.6.1.2.1.43.18.1.1.6"  // prtAlertCode
+    oidPrtAlertDescription            = ".1.3.6.1.2.1.43.18.1.1.8"  // prtAlertDescription
+)
+
+/* hrDeviceType value identifying the printer device's own row in hrDeviceTable */
+const hrDeviceTypePrinter = ".1.3.6.1.2.1.25.3.1.5"
+
+/* hrDeviceStatus values, as defined in the host resources mib */
+const (
+    DeviceStatusUnknown     = 1
+    DeviceStatusRunning     = 2
+    DeviceStatusWarning     = 3
+    DeviceStatusTesting     = 4
+    DeviceStatusDown        = 5
+)
+
+/* prtMarkerSuppliesLevel sentinels, as defined in the printer mib: values >= 0 are an actual
+   reading against prtMarkerSuppliesMaxCapacity, anything else means no numeric level is available */
+const (
+    levelOther        = -1 // vendor doesn't report a usable level
+    levelUnknown       = -2 // level isn't known
+    levelSomeRemaining = -3 // "some amount remains", but the amount can't be determined
+)
+
+/* Supply holds the parsed state of a single marker supply (toner, drum, etc.) */
+type Supply struct {
+    Name     string // prtMarkerSuppliesDescription
+    Color    string // resolved via prtMarkerSuppliesColorantIndex -> prtMarkerColorantValue
+    Level    int    // raw prtMarkerSuppliesLevel (may be a sentinel: -1 other, -2 unknown, -3 some remaining)
+    Capacity int    // raw prtMarkerSuppliesMaxCapacity
+    Percent  int    // Level*100/Capacity, or -1 if it can't be computed (sentinel level, or capacity <= 0)
+}
+
+/* Alert holds a single active entry out of prtAlertTable */
+type Alert struct {
+    Code        int    // prtAlertCode
+    Description string // prtAlertDescription
+}
+
+/* Creds holds the snmp credentials needed to poll a printer, v2c or v3 */
+type Creds struct {
+    Version   string // "v2c" or "v3"
+    Community string // used when Version == "v2c"
+    User      string // used when Version == "v3"
+    AuthProto string // "MD5", "SHA", etc.
+    AuthPass  string
+    PrivProto string // "DES", "AES", etc.
+    PrivPass  string
+}
+
+/* Result is everything we collect from a single printer in one poll */
+type Result struct {
+    Supplies     []Supply
+    Alerts       []Alert
+    DeviceStatus int
+}
+
+/*
+    newClient: builds a gosnmp client configured for v2c or v3 against the given address
+    params:    addr - ip or hostname of the printer
+               creds - snmp credentials to authenticate with
+               timeout - how long to wait for the printer to respond
+    returns:   client - configured (but not yet connected) gosnmp client
+               err - non-nil if the credentials are not usable
+*/
+func newClient(addr string, creds Creds, timeout time.Duration) (*gosnmp.GoSNMP, error) {
+    client := &gosnmp.GoSNMP{
+        Target:  addr,
+        Port:    161,
+        Timeout: timeout,
+        Retries: 1,
+    }
+
+    switch creds.Version {
+    case "v3":
+        if creds.User == "" {
+            return nil, fmt.Errorf("snmp v3 credentials for %s have no user", addr)
+        }
+        client.Version = gosnmp.Version3
+        client.SecurityModel = gosnmp.UserSecurityModel
+        client.MsgFlags = gosnmp.AuthPriv
+        client.SecurityParameters = &gosnmp.UsmSecurityParameters{
+            UserName:                 creds.User,
+            AuthenticationProtocol:   authProtoFromString(creds.AuthProto),
+            AuthenticationPassphrase: creds.AuthPass,
+            PrivacyProtocol:          privProtoFromString(creds.PrivProto),
+            PrivacyPassphrase:        creds.PrivPass,
+        }
+    default: // v2c, the common case for printers still on the loading dock
+        client.Version = gosnmp.Version2c
+        community := creds.Community
+        if community == "" {
+            community = "public" // the universal default, same as most printer firmware
+        }
+        client.Community = community
+    }
+
+    return client, nil
+}
+
+/* authProtoFromString maps a config string to the gosnmp auth protocol constant */
+func authProtoFromString(s string) gosnmp.SnmpV3AuthProtocol {
+    switch strings.ToUpper(s) {
+    case "SHA":
+        return gosnmp.SHA
+    case "MD5":
+        return gosnmp.MD5
+    default:
+        return gosnmp.NoAuth
+    }
+}
+
+/* privProtoFromString maps a config string to the gosnmp privacy protocol constant */
+func privProtoFromString(s string) gosnmp.SnmpV3PrivProtocol {
+    switch strings.ToUpper(s) {
+    case "AES":
+        return gosnmp.AES
+    case "DES":
+        return gosnmp.DES
+    default:
+        return gosnmp.NoPriv
+    }
+}
+
+/*
+    Poll: walks the printer mib and host resources mib on the given printer and
+          returns its current supply levels, device status, and active alerts
+    params: addr - ip or hostname of the printer
+            creds - snmp credentials to authenticate with
+            timeout - how long to wait for the printer to respond
+    returns: res - the collected supplies, alerts, and device status
+             err - non-nil if the printer could not be reached or walked
+*/
+func Poll(addr string, creds Creds, timeout time.Duration) (Result, error) {
+    var res Result
+
+    client, err := newClient(addr, creds, timeout)
+    if err != nil {
+        return res, err
+    }
+    if err := client.Connect(); err != nil {
+        return res, fmt.Errorf("snmp connect to %s: %w", addr, err)
+    }
+    defer client.Conn.Close()
+
+    colorByIndex, err := walkColorants(client)
+    if err != nil {
+        return res, err
+    }
+
+    res.Supplies, err = walkSupplies(client, colorByIndex)
+    if err != nil {
+        return res, err
+    }
+
+    res.DeviceStatus, err = getDeviceStatus(client)
+    if err != nil {
+        return res, err
+    }
+
+    res.Alerts, err = walkAlerts(client)
+    if err != nil {
+        return res, err
+    }
+
+    return res, nil
+}
+
+/* walkColorants builds a map of prtMarkerColorantIndex -> colorant name (e.g. "black") */
+func walkColorants(client *gosnmp.GoSNMP) (map[int]string, error) {
+    colorByIndex := make(map[int]string)
+    err := client.BulkWalk(oidPrtMarkerColorantValue, func(pdu gosnmp.SnmpPDU) error {
+        idx, err := lastOidIndex(pdu.Name)
+        if err != nil {
+            return nil // skip entries we can't index, rather than failing the whole walk
+        }
+        colorByIndex[idx] = strings.TrimRight(pduString(pdu), "\x00")
+        return nil
+    })
+    if err != nil {
+        return colorByIndex, fmt.Errorf("walking prtMarkerColorantTable: %w", err)
+    }
+    return colorByIndex, nil
+}
+
+/* walkSupplies joins the prtMarkerSuppliesTable columns into a slice of Supply */
+func walkSupplies(client *gosnmp.GoSNMP, colorByIndex map[int]string) ([]Supply, error) {
+    descByIndex := make(map[int]string)
+    levelByIndex := make(map[int]int)
+    capByIndex := make(map[int]int)
+    colorantIdxByIndex := make(map[int]int)
+
+    if err := client.BulkWalk(oidPrtMarkerSuppliesDescription, func(pdu gosnmp.SnmpPDU) error {
+        idx, err := lastOidIndex(pdu.Name)
+        if err == nil {
+            descByIndex[idx] = strings.TrimRight(pduString(pdu), "\x00")
+        }
+        return nil
+    }); err != nil {
+        return nil, fmt.Errorf("walking prtMarkerSuppliesDescription: %w", err)
+    }
+
+    if err := client.BulkWalk(oidPrtMarkerSuppliesLevel, func(pdu gosnmp.SnmpPDU) error {
+        idx, err := lastOidIndex(pdu.Name)
+        if err == nil {
+            levelByIndex[idx] = pduInt(pdu)
+        }
+        return nil
+    }); err != nil {
+        return nil, fmt.Errorf("walking prtMarkerSuppliesLevel: %w", err)
+    }
+
+    if err := client.BulkWalk(oidPrtMarkerSuppliesMaxCapacity, func(pdu gosnmp.SnmpPDU) error {
+        idx, err := lastOidIndex(pdu.Name)
+        if err == nil {
+            capByIndex[idx] = pduInt(pdu)
+        }
+        return nil
+    }); err != nil {
+        return nil, fmt.Errorf("walking prtMarkerSuppliesMaxCapacity: %w", err)
+    }
+
+    if err := client.BulkWalk(oidPrtMarkerSuppliesColorantIndex, func(pdu gosnmp.SnmpPDU) error {
+        idx, err := lastOidIndex(pdu.Name)
+        if err == nil {
+            colorantIdxByIndex[idx] = pduInt(pdu)
+        }
+        return nil
+    }); err != nil {
+        return nil, fmt.Errorf("walking prtMarkerSuppliesColorantIndex: %w", err)
+    }
+
+    supplies := make([]Supply, 0, len(descByIndex))
+    for idx, name := range descByIndex {
+        level := levelByIndex[idx]
+        capacity := capByIndex[idx]
+        supplies = append(supplies, Supply{
+            Name:     name,
+            Color:    colorByIndex[colorantIdxByIndex[idx]],
+            Level:    level,
+            Capacity: capacity,
+            Percent:  suppliesPercent(level, capacity),
+        })
+    }
+
+    return supplies, nil
+}
+
+/* suppliesPercent computes a marker supply's percent remaining, returning -1 when it can't be
+   determined: the mib's level sentinels (other/unknown/some-remaining) aren't real readings,
+   and a zero or missing capacity would make the division meaningless */
+func suppliesPercent(level, capacity int) int {
+    switch level {
+    case levelOther, levelUnknown, levelSomeRemaining:
+        return -1
+    }
+    if level < 0 || capacity <= 0 {
+        return -1
+    }
+    return level * 100 / capacity
+}
+
+/* getDeviceStatus fetches hrDeviceStatus for the printer's own row in hrDeviceTable, identified by
+   hrDeviceType == hrDeviceTypePrinter, rather than whichever hrDeviceTable row was walked last */
+func getDeviceStatus(client *gosnmp.GoSNMP) (int, error) {
+    statusByIndex := make(map[int]int)
+    if err := client.BulkWalk(oidHrDeviceStatus, func(pdu gosnmp.SnmpPDU) error {
+        idx, err := lastOidIndex(pdu.Name)
+        if err == nil {
+            statusByIndex[idx] = pduInt(pdu)
+        }
+        return nil
+    }); err != nil {
+        return DeviceStatusUnknown, fmt.Errorf("walking hrDeviceStatus: %w", err)
+    }
+
+    printerIdx := -1
+    if err := client.BulkWalk(oidHrDeviceType, func(pdu gosnmp.SnmpPDU) error {
+        if strings.TrimLeft(pduString(pdu), ".") == strings.TrimLeft(hrDeviceTypePrinter, ".") {
+            if idx, err := lastOidIndex(pdu.Name); err == nil {
+                printerIdx = idx
+            }
+        }
+        return nil
+    }); err != nil {
+        return DeviceStatusUnknown, fmt.Errorf("walking hrDeviceType: %w", err)
+    }
+
+    if status, ok := statusByIndex[printerIdx]; ok {
+        return status, nil
+    }
+    return DeviceStatusUnknown, nil
+}
+
+/* walkAlerts joins the prtAlertTable columns into a slice of Alert (paper jam, out of paper, cover open, etc.) */
+func walkAlerts(client *gosnmp.GoSNMP) ([]Alert, error) {
+    codeByIndex := make(map[int]int)
+    descByIndex := make(map[int]string)
+
+    if err := client.BulkWalk(oidPrtAlertCode, func(pdu gosnmp.SnmpPDU) error {
+        idx, err := lastOidIndex(pdu.Name)
+        if err == nil {
+            codeByIndex[idx] = pduInt(pdu)
+        }
+        return nil
+    }); err != nil {
+        return nil, fmt.Errorf("walking prtAlertCode: %w", err)
+    }
+
+    if err := client.BulkWalk(oidPrtAlertDescription, func(pdu gosnmp.SnmpPDU) error {
+        idx, err := lastOidIndex(pdu.Name)
+        if err == nil {
+            descByIndex[idx] = strings.TrimRight(pduString(pdu), "\x00")
+        }
+        return nil
+    }); err != nil {
+        return nil, fmt.Errorf("walking prtAlertDescription: %w", err)
+    }
+
+    alerts := make([]Alert, 0, len(codeByIndex))
+    for idx, code := range codeByIndex {
+        alerts = append(alerts, Alert{Code: code, Description: descByIndex[idx]})
+    }
+    return alerts, nil
+}
+
+/* lastOidIndex pulls the trailing table index off a walked oid, e.g. ".1.3.6.1.2.1.43.11.1.1.6.1.3" -> 3 */
+func lastOidIndex(oid string) (int, error) {
+    parts := strings.Split(oid, ".")
+    var idx int
+    if _, err := fmt.Sscanf(parts[len(parts)-1], "%d", &idx); err != nil {
+        return 0, err
+    }
+    return idx, nil
+}
+
+/* pduInt renders whatever snmp gave us for a numeric pdu as a go int */
+func pduInt(pdu gosnmp.SnmpPDU) int {
+    switch v := pdu.Value.(type) {
+    case int:
+        return v
+    case int64:
+        return int(v)
+    case uint:
+        return int(v)
+    case uint64:
+        return int(v)
+    default:
+        return 0
+    }
+}
+
+/* pduString renders whatever snmp gave us for a string-ish pdu as a go string */
+func pduString(pdu gosnmp.SnmpPDU) string {
+    if b, ok := pdu.Value.([]byte); ok {
+        return string(b)
+    }
+    return fmt.Sprintf("%v", pdu.Value)
+}
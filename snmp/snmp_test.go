@@ -0,0 +1,36 @@
+/*********************************************************
+*   package:    snmp
+*   desc:       tests for suppliesPercent's handling of prtMarkerSuppliesLevel sentinels
+*   files:      snmp_test.go
+*   author:     rory dudley (pinecat)
+*********************************************************/
+
+package snmp
+
+import "testing"
+
+func TestSuppliesPercent(t *testing.T) {
+    cases := []struct {
+        name     string
+        level    int
+        capacity int
+        want     int
+    }{
+        {"normal reading", 40, 100, 40},
+        {"normal reading, non-100 capacity", 5000, 20000, 25},
+        {"other sentinel", levelOther, 100, -1},
+        {"unknown sentinel", levelUnknown, 100, -1},
+        {"some remaining sentinel", levelSomeRemaining, 100, -1},
+        {"zero capacity", 10, 0, -1},
+        {"negative capacity", 10, -1, -1},
+        {"zero level, real capacity", 0, 100, 0},
+    }
+
+    for _, c := range cases {
+        t.Run(c.name, func(t *testing.T) {
+            if got := suppliesPercent(c.level, c.capacity); got != c.want {
+                t.Errorf("suppliesPercent(%d, %d) = %d, want %d", c.level, c.capacity, got, c.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,44 @@
+/*********************************************************
+*   program:    origami
+*   desc:       renders a tiny inline svg sparkline of a printer's recent toner history
+*   files:      sparkline.go
+*   author:     rory dudley (pinecat)
+*********************************************************/
+
+package main
+
+import (
+    "fmt" // for building the svg markup
+    "html/template" // for marking the svg as safe to embed unescaped
+)
+
+const (
+    sparklineWidth  = 100 // px
+    sparklineHeight = 20  // px
+)
+
+/*
+    sparkline: builds a tiny svg polyline of a printer's last N toner samples, for embedding in the index page
+    params:    name - the printer's display name
+    returns:   svg - the rendered <svg> markup, or an empty string if there's no history yet
+*/
+func sparkline(name string) template.HTML {
+    samples := historyFor(name)
+    if len(samples) < 2 { // not enough points to draw a line
+        return ""
+    }
+
+    points := ""
+    step := float64(sparklineWidth) / float64(len(samples)-1)
+    for i, s := range samples {
+        x := float64(i) * step
+        y := float64(sparklineHeight) - (float64(s.Toner)/100.0)*float64(sparklineHeight) // 100% toner at the top, 0% at the bottom
+        points += fmt.Sprintf("%.1f,%.1f ", x, y)
+    }
+
+    svg := fmt.Sprintf(
+        `<svg width="%d" height="%d" viewBox="0 0 %d %d"><polyline points="%s" fill="none" stroke="white" stroke-width="1.5"/></svg>`,
+        sparklineWidth, sparklineHeight, sparklineWidth, sparklineHeight, points,
+    )
+    return template.HTML(svg) // mark as safe so html/template doesn't escape the markup
+}